@@ -17,9 +17,6 @@ import (
 )
 
 func cmdImportData(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig, err error) {
-	if message.From.ID != botAdminID {
-		return
-	}
 	args := strings.Split(strings.TrimSpace(message.CommandArguments()), ";")
 	ctx := context.Background()
 	for _, arg := range args {
@@ -58,9 +55,6 @@ func cmdImportData(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageCo
 
 // cmdUpgradeData 数据结构更新
 func cmdUpgradeData(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig, err error) {
-	if message.From.ID != botAdminID {
-		return
-	}
 	ctx := context.Background()
 	users, err := storage.GetAllUsers(ctx)
 	if err != nil {
@@ -90,10 +84,43 @@ func cmdUpgradeData(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageC
 		Text: "done"}}, nil
 }
 
-func cmdListAllFriendCodes(message *tgbotapi.Message) (replyMessages []tgbotapi.MessageConfig, err error) {
-	if message.From.ID != botAdminID {
-		return
+// cmdMigrateQueues 部署后执行一次：加密旧队列的明文密码，补上旧队列的 LandedUIDs
+func cmdMigrateQueues(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig, err error) {
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, _projectID)
+	if err != nil {
+		return nil, Error{InnerError: err,
+			ReplyText: "查询队列时出错了",
+		}
+	}
+	defer client.Close()
+	queues, err := storage.GetAllOnboardQueues(ctx, client)
+	if err != nil {
+		return nil, Error{InnerError: err,
+			ReplyText: "查询队列时出错了",
+		}
 	}
+	var failed int
+	for _, queue := range queues {
+		if err = queue.EncryptLegacyPassword(ctx, client); err != nil {
+			_logger.Error().Err(err).Str("queue", queue.ID).Msg("encrypt legacy password failed")
+			failed++
+			continue
+		}
+		if err = queue.SyncLandedUIDs(ctx, client); err != nil {
+			_logger.Error().Err(err).Str("queue", queue.ID).Msg("sync landed uids failed")
+			failed++
+		}
+	}
+	return []tgbotapi.MessageConfig{{
+		BaseChat: tgbotapi.BaseChat{
+			ChatID:              message.Chat.ID,
+			ReplyToMessageID:    message.MessageID,
+			DisableNotification: true},
+		Text: fmt.Sprintf("done, queues: %d, failed: %d", len(queues), failed)}}, nil
+}
+
+func cmdListAllFriendCodes(message *tgbotapi.Message) (replyMessages []tgbotapi.MessageConfig, err error) {
 	ctx := context.Background()
 	users, err := storage.GetAllUsers(ctx)
 	if err != nil {
@@ -356,6 +356,11 @@ func cmdOpenIsland(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageCo
 		island.Info = islandInfo
 		island.OpenTime = time.Now()
 		island.Update(ctx)
+		if len(island.OnBoardQueueID) > 0 {
+			if queue, err := island.GetOnboardQueue(ctx); err == nil && !queue.Dismissed {
+				refreshQueueCards(queue)
+			}
+		}
 	}
 	var btn = tgbotapi.NewInlineKeyboardButtonData("点此创建新队列", "/queue")
 	var replyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(btn))
@@ -410,7 +415,7 @@ func cmdCloseIsland(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageC
 		island.AirportIsOpen = false
 		island.Info = ""
 		if len(island.OnBoardQueueID) > 0 {
-			queue, lerr := island.ClearOldOnboardQueue(ctx)
+			_, lerr := dismissIslandQueue(ctx, island)
 			if lerr != nil {
 				return []tgbotapi.MessageConfig{
 					{BaseChat: tgbotapi.BaseChat{
@@ -420,10 +425,9 @@ func cmdCloseIsland(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageC
 					{BaseChat: tgbotapi.BaseChat{
 						ChatID:              int64(botAdminID),
 						DisableNotification: false},
-						Text: "关闭岛屿时，清理队列时，发生错误。已通知bot 管理员。" + err.Error()},
+						Text: "关闭岛屿时，清理队列时，发生错误。已通知bot 管理员。" + lerr.Error()},
 				}, nil
 			}
-			replyMessage = append(replyMessage, notifyQueueDissmised(queue)...)
 		}
 		island.Update(ctx)
 	}
@@ -484,6 +488,7 @@ func cmdDTCPriceUpdate(message *tgbotapi.Message) (replyMessage []tgbotapi.Messa
 				ReplyText: "更新报价时出错狸",
 			}
 		}
+		offerSellQueue(ctx, uid, int(price))
 	} else if len(args)%2 == 0 {
 		var weekDayNames = []string{"SUN", "SUN_AM", "MON_AM", "MON_PM", "TUE_AM", "TUE_PM", "WED_AM", "WED_PM", "THU_AM", "THU_PM", "FRI_AM", "FRI_PM", "SAT_AM", "SAT_PM"}
 		var prices []int = make([]int, 13)
@@ -961,27 +966,11 @@ func getTopPriceUsersAndLowestPriceUser(ctx context.Context, chatID int64, local
 		if !strings.HasSuffix(island.Name, "岛") {
 			island.Name += "岛"
 		}
-		var nowLoc = time.Now().In(island.Timezone.Location())
-		if nowLoc.Hour() < 5 {
-			nowLoc = nowLoc.Add(-(time.Duration(nowLoc.Hour()+1) * time.Hour))
-		}
-		var weekStartDateLoc = nowLoc.AddDate(0, 0, 0-int(nowLoc.Weekday()))
-		weekStartDateLoc = time.Date(weekStartDateLoc.Year(), weekStartDateLoc.Month(), weekStartDateLoc.Day(), 0, 0, 0, 0, island.Timezone.Location())
-		var weekStartDate = weekStartDateLoc.UTC()
-		var weekEndDate = weekStartDate.AddDate(0, 0, 7)
-		weekStartDate = weekStartDate.Add(5 * time.Hour)
 		uid := u.ID
 		if residentUID > 0 {
 			uid = residentUID
 		}
-		island.WeekPriceHistory, err = storage.GetWeeklyDTCPriceHistory(ctx, uid, weekStartDate, weekEndDate)
-		if err != nil {
-			_logger.Error().Err(err).Int("uid", u.ID).
-				Time("weekStart", weekStartDate).
-				Time("weekEndDate", weekStartDate).
-				Time("weekStartDateLoc", weekStartDateLoc).
-				Msg("GetWeeklyDTCPriceHistory")
-		}
+		island.WeekPriceHistory = getIslandWeekPriceHistory(ctx, island, uid)
 		u.Island = island
 		priceUsers = append(priceUsers, u)
 	}
@@ -1051,6 +1040,28 @@ func getTopPriceUsersAndLowestPriceUser(ctx context.Context, chatID int64, local
 	return
 }
 
+// getIslandWeekPriceHistory 获得岛屿当周自周日 5 点起的报价
+func getIslandWeekPriceHistory(ctx context.Context, island *storage.Island, uid int) (priceHistory []storage.TurnipPrice) {
+	var nowLoc = time.Now().In(island.Timezone.Location())
+	if nowLoc.Hour() < 5 {
+		nowLoc = nowLoc.Add(-(time.Duration(nowLoc.Hour()+1) * time.Hour))
+	}
+	var weekStartDateLoc = nowLoc.AddDate(0, 0, 0-int(nowLoc.Weekday()))
+	weekStartDateLoc = time.Date(weekStartDateLoc.Year(), weekStartDateLoc.Month(), weekStartDateLoc.Day(), 0, 0, 0, 0, island.Timezone.Location())
+	var weekStartDate = weekStartDateLoc.UTC()
+	var weekEndDate = weekStartDate.AddDate(0, 0, 7)
+	weekStartDate = weekStartDate.Add(5 * time.Hour)
+	priceHistory, err := storage.GetWeeklyDTCPriceHistory(ctx, uid, weekStartDate, weekEndDate)
+	if err != nil {
+		_logger.Error().Err(err).Int("uid", uid).
+			Time("weekStart", weekStartDate).
+			Time("weekEndDate", weekEndDate).
+			Time("weekStartDateLoc", weekStartDateLoc).
+			Msg("GetWeeklyDTCPriceHistory")
+	}
+	return
+}
+
 func formatIslandDTCPrice(user storage.User, rank int) string {
 	if !strings.HasSuffix(user.Island.Name, "岛") {
 		user.Island.Name += "岛"
@@ -1089,9 +1100,6 @@ func formatIslandDTCPrice(user storage.User, rank int) string {
 }
 
 func cmdWhois(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig, err error) {
-	if message.Chat.IsPrivate() {
-		return
-	}
 	query := strings.TrimSpace(message.CommandArguments())
 	if len(query) == 0 {
 		return
@@ -1230,9 +1238,6 @@ func formatUserSearchResult(ctx context.Context, usermap map[string]struct{}, us
 }
 
 func cmdSearchAnimalCrossingInfo(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig, err error) {
-	if message.Chat.IsPrivate() {
-		return
-	}
 	args := strings.TrimSpace(message.CommandArguments())
 	ctx := context.Background()
 	var us []storage.User
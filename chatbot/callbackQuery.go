@@ -60,12 +60,72 @@ func (c ChatBot) HandleCallbackQuery(query *tgbotapi.CallbackQuery) {
 	} else if strings.HasPrefix(query.Data, "/coming_") {
 		processed = true
 		result, err = callbackQueryComing(query)
-	} else if strings.HasPrefix(query.Data, "/done_") || strings.HasPrefix(query.Data, "/sorry_") {
+	} else if strings.HasPrefix(query.Data, "/done_") || strings.HasPrefix(query.Data, "/finish_") || strings.HasPrefix(query.Data, "/sorry_") {
 		processed = true
 		result, err = callbackQueryDoneOrSorry(query)
 	} else if strings.HasPrefix(query.Data, "/dismiss_") {
 		processed = true
 		result, err = callbackQueryDismissQueue(query)
+	} else if strings.HasPrefix(query.Data, "/pick_") {
+		processed = true
+		result, err = callbackQueryPickGuests(query)
+	} else if strings.HasPrefix(query.Data, "/pickpage_") {
+		processed = true
+		result, err = callbackQueryPickPage(query)
+	} else if strings.HasPrefix(query.Data, "/picknext_") {
+		processed = true
+		result, err = callbackQueryPickNextGuest(query)
+	} else if strings.HasPrefix(query.Data, "/moveup_") || strings.HasPrefix(query.Data, "/movedown_") {
+		processed = true
+		result, err = callbackQueryMoveGuest(query)
+	} else if strings.HasPrefix(query.Data, "/memberpage_") {
+		processed = true
+		result, err = callbackQueryMembersPage(query)
+	} else if strings.HasPrefix(query.Data, "/kick_") || strings.HasPrefix(query.Data, "/ban_") {
+		processed = true
+		result, err = callbackQueryKickGuest(query)
+	} else if strings.HasPrefix(query.Data, "/unban_") {
+		processed = true
+		result, err = callbackQueryUnbanGuest(query)
+	} else if strings.HasPrefix(query.Data, "/cohost_") {
+		processed = true
+		result, err = callbackQueryCoHosts(query)
+	} else if strings.HasPrefix(query.Data, "/rmcohost_") {
+		processed = true
+		result, err = callbackQueryRemoveCoHost(query)
+	} else if strings.HasPrefix(query.Data, "/pause_") || strings.HasPrefix(query.Data, "/resume_") {
+		processed = true
+		result, err = callbackQueryPauseQueue(query)
+	} else if strings.HasPrefix(query.Data, "/crash_") {
+		processed = true
+		result, err = callbackQueryIslandCrashed(query)
+	} else if strings.HasPrefix(query.Data, "/purpose_") {
+		processed = true
+		result, err = callbackQueryGuestPurpose(query)
+	} else if strings.HasPrefix(query.Data, "/trips_") {
+		processed = true
+		result, err = callbackQueryGuestTrips(query)
+	} else if strings.HasPrefix(query.Data, "/broadcast_") {
+		processed = true
+		result, err = callbackQueryQueueBroadcast(query)
+	} else if strings.HasPrefix(query.Data, "/rejoin_") {
+		processed = true
+		result, err = callbackQueryRejoinIsland(query)
+	} else if strings.HasPrefix(query.Data, "/usetemplate_") {
+		processed = true
+		result, err = callbackQueryUseQueueTemplate(query)
+	} else if strings.HasPrefix(query.Data, "/leavewait_") {
+		processed = true
+		result, err = callbackQueryLeaveWaitlist(query)
+	} else if strings.HasPrefix(query.Data, "/unprejoin_") {
+		processed = true
+		result, err = callbackQueryLeaveSchedule(query)
+	} else if strings.HasPrefix(query.Data, "/cancelschedule_") {
+		processed = true
+		result, err = callbackQueryCancelSchedule(query)
+	} else if strings.HasPrefix(query.Data, "/sellqueue_") {
+		processed = true
+		result, err = callbackQuerySellQueue(query)
 	} else if strings.HasPrefix(query.Data, "/manageFriendCodes") {
 		processed = true
 		result, err = callbackQueryManageFriendCodes(query)
@@ -163,13 +223,20 @@ func callbackQueryUpdateQueuePassword(query *tgbotapi.CallbackQuery) (callbackCo
 			ShowAlert:       false,
 		}, nil
 	}
+	if !queue.IsHost(int64(query.From.ID)) {
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "只有岛主才能操作狸",
+			ShowAlert:       false,
+		}, nil
+	}
 	if queue != nil && !queue.Dismissed {
 		_, err = tgbot.Send(tgbotapi.MessageConfig{
 			BaseChat: tgbotapi.BaseChat{
 				ChatID:      int64(query.From.ID),
 				ReplyMarkup: tgbotapi.ForceReply{ForceReply: true, Selective: true},
 			},
-			Text: "请输入新的密码",
+			Text: updatePasswordPrompt + queue.ID,
 		})
 		if err != nil {
 			return tgbotapi.CallbackConfig{
@@ -222,72 +289,27 @@ func callbackQueryJoinQueue(query *tgbotapi.CallbackQuery) (callbackConfig tgbot
 			ShowAlert:       false,
 		}, nil
 	}
-	if queue.OwnerID == uid {
+	replyText, showAlert, waitlisted, err := joinQueue(ctx, client, queue, uid, username)
+	if err != nil {
 		return tgbotapi.CallbackConfig{
 			CallbackQueryID: query.ID,
-			Text:            "自己不用排自己的队伍狸……",
+			Text:            "failed",
 			ShowAlert:       false,
 		}, nil
 	}
-	if err = queue.Append(ctx, client, uid, username); err != nil {
-		if err.Error() == "already in this queue" {
-			return tgbotapi.CallbackConfig{
-				CallbackQueryID: query.ID,
-				Text:            "您已经加入了这个队列",
-				ShowAlert:       false,
-			}, nil
-		} else if err.Error() == "already land island" {
-			return tgbotapi.CallbackConfig{
-				CallbackQueryID: query.ID,
-				Text:            "请离岛后再重新排队",
-				ShowAlert:       false,
-			}, nil
-		}
-		_logger.Error().Err(err).Msg("append queue failed")
+	if len(replyText) > 0 {
 		return tgbotapi.CallbackConfig{
 			CallbackQueryID: query.ID,
-			Text:            "failed",
-			ShowAlert:       false,
+			Text:            replyText,
+			ShowAlert:       showAlert,
 		}, nil
 	}
-	t := queue.Len()
-	l, err := queue.GetPosition(uid)
-	if err != nil {
-		t++
-	}
-	if queue.IsAuto && queue.LandedLen() < queue.MaxGuestCount {
-		sendNotify(ctx, client, queue)
-	} else {
-		var queueType string
-		if queue.IsAuto {
-			queueType = "自助队列"
-		} else {
-			queueType = "岛主手动控制队列"
-		}
-		var myPositionBtn = tgbotapi.NewInlineKeyboardButtonData("我的位置？", fmt.Sprintf("/position_%s|%d", queue.ID, time.Now().Unix()))
-		var leaveBtn = tgbotapi.NewInlineKeyboardButtonData("离开队列："+queue.Name, "/leave_"+queue.ID)
-		var replyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(myPositionBtn, leaveBtn))
-		tgbot.Send(tgbotapi.MessageConfig{
-			BaseChat: tgbotapi.BaseChat{
-				ChatID:      uid,
-				ReplyMarkup: replyMarkup,
-			},
-			Text: fmt.Sprintf("已加入前往 %s 的队列中排队，本队列为 %s ，当前位置：%d/%d。\n当前岛上有 %d 个客人", queue.Name, queueType, l, t, queue.LandedLen()),
-		})
-		sentMsg, err := tgbot.Send(tgbotapi.MessageConfig{
-			BaseChat: tgbotapi.BaseChat{
-				ChatID: queue.OwnerID,
-			},
-			Text: fmt.Sprintf("@%s 加入了您创建的队列", username),
-		})
-		if err != nil {
-			_logger.Error().Err(err).Msg("send msg failed")
-		} else {
-			go func() {
-				time.Sleep(55 * time.Second)
-				tgbot.DeleteMessage(tgbotapi.NewDeleteMessage(sentMsg.Chat.ID, sentMsg.MessageID))
-			}()
-		}
+	if waitlisted {
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "队列已满，已加入候补名单",
+			ShowAlert:       false,
+		}, nil
 	}
 	return tgbotapi.CallbackConfig{
 		CallbackQueryID: query.ID,
@@ -333,16 +355,47 @@ func callbackQueryShowQueueMembers(query *tgbotapi.CallbackQuery) (callbackConfi
 			ShowAlert:       false,
 		}, nil
 	}
+	var isOwner = queue.IsHost(int64(query.From.ID))
+	if !isOwner {
+		switch queue.Privacy() {
+		case storage.QueuePrivacyOwner:
+			return tgbotapi.CallbackConfig{
+				CallbackQueryID: query.ID,
+				Text:            "只有岛主可以查看队列成员",
+				ShowAlert:       false,
+			}, nil
+		case storage.QueuePrivacyCount:
+			return tgbotapi.CallbackConfig{
+				CallbackQueryID: query.ID,
+				Text:            fmt.Sprintf("当前在岛 %d 人，排队中 %d 人", queue.LandedLen(), queue.Len()),
+				ShowAlert:       true,
+			}, nil
+		}
+	}
+	var reputations map[int64]storage.GuestReputation
+	if isOwner {
+		var uids []int64
+		for _, p := range queue.Landed {
+			uids = append(uids, p.UID)
+		}
+		for _, p := range queue.Queue {
+			uids = append(uids, p.UID)
+		}
+		if reputations, err = storage.GetGuestReputations(ctx, uids); err != nil {
+			_logger.Error().Err(err).Msg("query guest reputations failed")
+		}
+	}
+	displayName := func(name string, uid int64) string {
+		if !isOwner {
+			return guestDisplayName(name, uid)
+		}
+		r, ok := reputations[uid]
+		return guestDisplayName(name, uid) + guestVisitText(queue, uid) + reputationSummary(r, ok)
+	}
 	replyText := "当前在岛\n"
 	var landed []string
 	for _, p := range queue.Landed {
-		var name string
-		if len(p.Name) > 0 {
-			name = "@" + p.Name
-		} else {
-			name = fmt.Sprintf("tg://user?id=%d", p.UID)
-		}
-		landed = append(landed, name)
+		landed = append(landed, displayName(p.Name, p.UID))
 	}
 	if len(landed) > 0 {
 		replyText += strings.Join(landed, "\n")
@@ -352,25 +405,35 @@ func callbackQueryShowQueueMembers(query *tgbotapi.CallbackQuery) (callbackConfi
 	replyText += "\n排队中\n"
 	var queueInfo []string
 	for _, p := range queue.Queue {
-		var name string
-		if len(p.Name) > 0 {
-			name = "@" + p.Name
-		} else {
-			name = fmt.Sprintf("tg://user?id=%d", p.UID)
-		}
-		queueInfo = append(queueInfo, name)
+		queueInfo = append(queueInfo, displayName(p.Name, p.UID))
 	}
-	if len(landed) > 0 {
+	if len(queueInfo) > 0 {
 		replyText += strings.Join(queueInfo, "\n")
 	} else {
 		replyText += "0人"
 	}
-	_, err = tgbot.Send(tgbotapi.MessageConfig{
+	if isOwner && queue.WaitlistLen() > 0 {
+		var waitlist []string
+		for _, p := range queue.Waitlist {
+			waitlist = append(waitlist, guestDisplayName(p.Name, p.UID)+guestVisitText(queue, p.UID))
+		}
+		replyText += "\n候补中\n" + strings.Join(waitlist, "\n")
+	}
+	if isOwner {
+		replyText += "\n\n" + queueThroughputText(queue)
+	}
+	var membersMessage = tgbotapi.MessageConfig{
 		BaseChat: tgbotapi.BaseChat{
 			ChatID: int64(query.From.ID),
 		},
 		Text: replyText,
-	})
+	}
+	if isOwner {
+		if replyMarkup := queueMembersReplyMarkup(queue, 0); replyMarkup != nil {
+			membersMessage.ReplyMarkup = replyMarkup
+		}
+	}
+	_, err = tgbot.Send(membersMessage)
 	if err != nil {
 		return tgbotapi.CallbackConfig{
 			CallbackQueryID: query.ID,
@@ -428,6 +491,7 @@ func callbackQueryLeaveQueue(query *tgbotapi.CallbackQuery) (callbackConfig tgbo
 			ShowAlert:       false,
 		}, nil
 	}
+	logQueueEvent(queue, storage.QueueEventLeave, int64(uid), username)
 	_, err = tgbot.Send(tgbotapi.EditMessageTextConfig{
 		BaseEdit: tgbotapi.BaseEdit{
 			ChatID:    int64(uid),
@@ -454,6 +518,7 @@ func callbackQueryLeaveQueue(query *tgbotapi.CallbackQuery) (callbackConfig tgbo
 			}
 		}()
 	}
+	promoteWaitlist(ctx, client, queue)
 	if queue.IsAuto && queue.MaxGuestCount > 0 && queue.LandedLen() < queue.MaxGuestCount {
 		sendNotify(ctx, client, queue)
 	}
@@ -486,6 +551,13 @@ func callbackQueryNextQueue(query *tgbotapi.CallbackQuery) (callbackConfig tgbot
 			ShowAlert:       false,
 		}, nil
 	}
+	if !queue.IsHost(int64(query.From.ID)) {
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "只有岛主才能操作狸",
+			ShowAlert:       false,
+		}, nil
+	}
 	if queue.MaxGuestCount > 0 {
 		if queue.LandedLen() == queue.MaxGuestCount {
 			_logger.Warn().Msg("island is full")
@@ -511,6 +583,20 @@ func callbackQueryNextQueue(query *tgbotapi.CallbackQuery) (callbackConfig tgbot
 				ShowAlert:       false,
 			}, nil
 		}
+		if err.Error() == "queue is paused" {
+			return tgbotapi.CallbackConfig{
+				CallbackQueryID: query.ID,
+				Text:            "队列已暂停邀请，请先恢复邀请",
+				ShowAlert:       false,
+			}, nil
+		}
+		if err.Error() == "lottery is pending" {
+			return tgbotapi.CallbackConfig{
+				CallbackQueryID: query.ID,
+				Text:            "抽签报名中，抽签后才能邀请",
+				ShowAlert:       false,
+			}, nil
+		}
 
 		return tgbotapi.CallbackConfig{
 			CallbackQueryID: query.ID,
@@ -527,6 +613,12 @@ func callbackQueryNextQueue(query *tgbotapi.CallbackQuery) (callbackConfig tgbot
 }
 
 func sendNotify(ctx context.Context, client *firestore.Client, queue *storage.OnboardQueue) (err error) {
+	if queue.Paused {
+		return errors.New("queue is paused")
+	}
+	if queue.IsLotteryPending() {
+		return errors.New("lottery is pending")
+	}
 	var chatID int64
 	var comingBtn = tgbotapi.NewInlineKeyboardButtonData("准备起飞！"+queue.Name, "/coming_"+queue.ID)
 	var sorryBtn = tgbotapi.NewInlineKeyboardButtonData("抱歉不能来了……", "/sorry_"+queue.ID)
@@ -536,11 +628,22 @@ func sendNotify(ctx context.Context, client *firestore.Client, queue *storage.On
 		tgbotapi.NewInlineKeyboardRow(doneBtn),
 		tgbotapi.NewInlineKeyboardRow(sorryBtn))
 	var replymessages []tgbotapi.MessageConfig
+	var names = make(map[int64]string)
+	for _, g := range queue.Queue {
+		names[g.UID] = g.Name
+	}
 	var queueType string
 	if queue.IsAuto {
 		queueType = "\n本次排队是自助队列，当您离岛时，需要您主动点击“我要回家啦！”按钮"
 	}
-	var replyText = fmt.Sprintf("轮到你了！\n目标岛屿：%s\n密码：*%s*\n%s\n如果不能前往，请务必和岛主联系！%s", queue.Name, queue.Password, markdownSafe(queue.IslandInfo), queueType)
+	if queue.InviteTimeout > 0 {
+		queueType += fmt.Sprintf("\n请在 %d 分钟内点击“准备起飞！”确认，超时将自动跳过", queue.InviteTimeout)
+	}
+	password, err := queue.PlainPassword()
+	if err != nil {
+		return
+	}
+	var replyText = fmt.Sprintf("轮到你了！\n目标岛屿：%s\n密码：*%s*\n%s\n如果不能前往，请务必和岛主联系！%s", queue.Name, password, markdownSafe(queue.IslandInfo), queueType)
 	if queue.IsAuto && queue.LandedLen() < queue.MaxGuestCount {
 		batch := client.Batch()
 		i := 0
@@ -561,11 +664,21 @@ func sendNotify(ctx context.Context, client *firestore.Client, queue *storage.On
 				{Path: "queue", Value: firestore.ArrayRemove(guest)},
 				{Path: "uids", Value: firestore.ArrayRemove(guest.UID)},
 				{Path: "landed", Value: firestore.ArrayUnion(guest)},
+				{Path: "LandedUIDs", Value: firestore.ArrayUnion(guest.UID)},
 			})
 		}
 		if i > 0 {
+			// 同步更新内存中的队列，调用方接着检查在岛人数时不会重复邀请
+			queue.Landed = append(queue.Landed, queue.Queue[:i]...)
+			for _, g := range queue.Queue[:i] {
+				queue.LandedUIDs = append(queue.LandedUIDs, g.UID)
+			}
 			copy(queue.Queue[0:], queue.Queue[i:])
 			queue.Queue = queue.Queue[:len(queue.Queue)-i]
+			queue.UIDs = queue.UIDs[:0]
+			for _, g := range queue.Queue {
+				queue.UIDs = append(queue.UIDs, g.UID)
+			}
 			_, err := batch.Commit(ctx)
 			if err != nil {
 				// Handle any errors in an appropriate way, such as returning them.
@@ -592,26 +705,38 @@ func sendNotify(ctx context.Context, client *firestore.Client, queue *storage.On
 		if err != nil {
 			_logger.Error().Err(err).Msg("notify next failed")
 		}
+		if err = queue.RecordLanded(ctx, client, m.ChatID); err != nil {
+			_logger.Error().Err(err).Int64("uid", m.ChatID).Msg("record landed time failed")
+		}
+		if err = queue.SetInviteDeadline(ctx, client, m.ChatID); err != nil {
+			_logger.Error().Err(err).Int64("uid", m.ChatID).Msg("set invite deadline failed")
+		}
+		logQueueEvent(queue, storage.QueueEventInvite, m.ChatID, names[m.ChatID])
 	}
+	promoteWaitlist(ctx, client, queue)
+	notifyUpcomingGuests(queue)
+	return
+}
+
+// notifyUpcomingGuests 提醒队列中最前面的 2 位客人做好准备
+func notifyUpcomingGuests(queue *storage.OnboardQueue) {
 	for i := 0; i < 2 && i < queue.Len(); i++ {
 		var sorryBtn = tgbotapi.NewInlineKeyboardButtonData("抱歉不能来了……", "/sorry_"+queue.ID)
 		var replyMarkup = tgbotapi.NewInlineKeyboardMarkup(
 			tgbotapi.NewInlineKeyboardRow(sorryBtn))
 		uid := queue.Queue[i].UID
-		l, err := queue.GetPosition(uid)
-		if err != nil {
-			_logger.Error().Err(err).Int64("uid", uid).Msg("get postion failed")
-		}
-		_, err = tgbot.Send(tgbotapi.MessageConfig{
+		_, err := tgbot.Send(tgbotapi.MessageConfig{
 			BaseChat: tgbotapi.BaseChat{
 				ChatID:      uid,
 				ReplyMarkup: replyMarkup,
 			},
-			Text:      fmt.Sprintf("提醒！\n岛屿：%s\n\n*马上就要轮到你了！\n请做好准备并确认：网络没问题，行李已带齐，前往机场开始与工作鸟员对话，准备使用密码搜索岛屿，停在密码输入界面等待。*\n如果不能前往，请务必和岛主联系！\n当前位置：%d/%d", markdownSafe(queue.IslandInfo), l, queue.Len()),
+			Text:      fmt.Sprintf("提醒！\n岛屿：%s\n\n*马上就要轮到你了！\n请做好准备并确认：网络没问题，行李已带齐，前往机场开始与工作鸟员对话，准备使用密码搜索岛屿，停在密码输入界面等待。*\n如果不能前往，请务必和岛主联系！\n当前位置：%d/%d", markdownSafe(queue.IslandInfo), i+1, queue.Len()),
 			ParseMode: "MarkdownV2",
 		})
+		if err != nil {
+			_logger.Info().Err(err).Int64("uid", uid).Msg("notify upcoming guest failed")
+		}
 	}
-	return
 }
 
 func callbackQueryShowQueueInfo(query *tgbotapi.CallbackQuery) (callbackConfig tgbotapi.CallbackConfig, err error) {
@@ -663,15 +788,13 @@ func callbackQueryShowQueueInfo(query *tgbotapi.CallbackQuery) (callbackConfig t
 			ShowAlert:       false,
 		}, nil
 	}
-	var myPositionBtn = tgbotapi.NewInlineKeyboardButtonData("我的位置？", fmt.Sprintf("/position_%s|%d", queue.ID, unixtime))
-	var leaveBtn = tgbotapi.NewInlineKeyboardButtonData("离开队列："+queue.Name, "/leave_"+queue.ID)
-	var replyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(myPositionBtn, leaveBtn))
+	var replyMarkup = queueGuestReplyMarkup(queue, unixtime)
 	tgbot.Send(tgbotapi.MessageConfig{
 		BaseChat: tgbotapi.BaseChat{
 			ChatID:      uid,
 			ReplyMarkup: replyMarkup,
 		},
-		Text: fmt.Sprintf("正在队列：%s 中排队，当前位置：%d/%d。\n当前岛上有 %d 个客人\n已排队 %d 分钟", queue.Name, l, t, queue.LandedLen(), int(time.Since(time.Unix(unixtime, 0)).Minutes())),
+		Text: fmt.Sprintf("正在队列：%s 中排队，当前位置：%d/%d。\n当前岛上有 %d 个客人\n已排队 %d 分钟%s", queue.Name, l, t, queue.LandedLen(), int(time.Since(time.Unix(unixtime, 0)).Minutes()), estimatedWaitText(queue, l)),
 	})
 	return tgbotapi.CallbackConfig{
 		CallbackQueryID: query.ID,
@@ -706,11 +829,21 @@ func callbackQueryDismissQueue(query *tgbotapi.CallbackQuery) (callbackConfig tg
 			ShowAlert:       false,
 		}, nil
 	}
-	queue.Dismissed = true
-	for _, replyMsg := range notifyQueueDissmised(queue) {
-		tgbot.Send(replyMsg)
+	if !queue.IsHost(int64(query.From.ID)) {
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "只有岛主才能操作狸",
+			ShowAlert:       false,
+		}, nil
+	}
+	if err = dismissQueue(ctx, client, queue); err != nil {
+		_logger.Error().Err(err).Str("queue", queueID).Msg("dismiss queue failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
 	}
-	queue.Delete(ctx, client)
 	_, err = tgbot.Send(tgbotapi.EditMessageTextConfig{
 		BaseEdit: tgbotapi.BaseEdit{
 			ChatID:    int64(query.From.ID),
@@ -771,16 +904,14 @@ func callbackQueryGetPositionInQueue(query *tgbotapi.CallbackQuery) (callbackCon
 			ShowAlert:       false,
 		}, nil
 	}
-	var myPositionBtn = tgbotapi.NewInlineKeyboardButtonData("我的位置？", fmt.Sprintf("/position_%s|%d", queue.ID, unixtime))
-	var leaveBtn = tgbotapi.NewInlineKeyboardButtonData("离开队列："+queue.Name, "/leave_"+queue.ID)
-	var replyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(myPositionBtn, leaveBtn))
+	var replyMarkup = queueGuestReplyMarkup(queue, unixtime)
 	_, err = tgbot.Send(tgbotapi.EditMessageTextConfig{
 		BaseEdit: tgbotapi.BaseEdit{
 			ChatID:      uid,
 			MessageID:   query.Message.MessageID,
 			ReplyMarkup: &replyMarkup,
 		},
-		Text: fmt.Sprintf("正在队列：%s 中排队，当前位置：%d/%d。\n当前岛上有 %d 个客人\n已排队 %d 分钟", queue.Name, l, t, queue.LandedLen(), int(time.Since(time.Unix(unixtime, 0)).Minutes())),
+		Text: fmt.Sprintf("正在队列：%s 中排队，当前位置：%d/%d。\n当前岛上有 %d 个客人\n已排队 %d 分钟%s", queue.Name, l, t, queue.LandedLen(), int(time.Since(time.Unix(unixtime, 0)).Minutes()), estimatedWaitText(queue, l)),
 	})
 	if err != nil {
 		if e, ok := err.(tgbotapi.Error); !(ok && strings.HasPrefix(e.Message, "Bad Request: message is not modified:")) {
@@ -835,6 +966,26 @@ func callbackQueryComing(query *tgbotapi.CallbackQuery) (callbackConfig tgbotapi
 			ShowAlert:       false,
 		}, nil
 	}
+	if queue.InviteTimeout > 0 && !queue.IsLanded(int64(uid)) {
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "确认已超时，您已被跳过，请重新排队",
+			ShowAlert:       true,
+		}, nil
+	}
+	if err = queue.ClearInviteDeadline(ctx, client, int64(uid)); err != nil {
+		_logger.Error().Err(err).Int("uid", uid).Msg("clear invite deadline failed")
+	}
+	logQueueEvent(queue, storage.QueueEventComing, int64(uid), name)
+	password, err := queue.PlainPassword()
+	if err != nil {
+		_logger.Error().Err(err).Str("queue", queue.ID).Msg("decrypt password failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
 	var sorryBtn = tgbotapi.NewInlineKeyboardButtonData("抱歉不能来了", "/sorry_"+queue.ID)
 	var doneBtn = tgbotapi.NewInlineKeyboardButtonData("我要回家啦！", "/done_"+queue.ID)
 	var replyMarkup1 = tgbotapi.NewInlineKeyboardMarkup(
@@ -846,7 +997,7 @@ func callbackQueryComing(query *tgbotapi.CallbackQuery) (callbackConfig tgbotapi
 			MessageID:   query.Message.MessageID,
 			ReplyMarkup: &replyMarkup1,
 		},
-		Text:      fmt.Sprintf("轮到你了！\n密码：*%s*\n如果不能前往，请务必和岛主联系！\n如果回家了，也请通知一下岛主。", queue.Password),
+		Text:      fmt.Sprintf("轮到你了！\n密码：*%s*\n如果不能前往，请务必和岛主联系！\n如果回家了，也请通知一下岛主。", password),
 		ParseMode: "MarkdownV2",
 	})
 	if err != nil {
@@ -859,29 +1010,7 @@ func callbackQueryComing(query *tgbotapi.CallbackQuery) (callbackConfig tgbotapi
 
 	replyText += fmt.Sprintf("\n队列剩余：%d\n当前在岛：%d\n", queue.Len(), queue.LandedLen())
 
-	var shareBtn = tgbotapi.NewInlineKeyboardButtonSwitch("分享队列："+queue.Name, "/share_"+queue.ID)
-	var dismissBtn = tgbotapi.NewInlineKeyboardButtonData("解散队列", "/dismiss_"+queue.ID)
-	var listBtn = tgbotapi.NewInlineKeyboardButtonData("查看队列", "/showqueuemember_"+queue.ID)
-	var updatePasswordBtn = tgbotapi.NewInlineKeyboardButtonData("修改密码", "/updatepassword_"+queue.ID)
-	var nextBtn = tgbotapi.NewInlineKeyboardButtonData("有请下一位", "/next_"+queue.ID)
-	var replyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(shareBtn, dismissBtn),
-		tgbotapi.NewInlineKeyboardRow(listBtn, updatePasswordBtn),
-		tgbotapi.NewInlineKeyboardRow(nextBtn))
-
-	_, err = tgbot.Send(tgbotapi.MessageConfig{
-		BaseChat: tgbotapi.BaseChat{
-			ChatID:      queue.OwnerID,
-			ReplyMarkup: replyMarkup,
-		},
-		Text: replyText,
-	})
-	if err != nil {
-		_logger.Error().Err(err).Int("uid", query.From.ID).
-			Int("msgID", query.Message.MessageID).
-			Str("action", action).
-			Msg("message send failed")
-		return
-	}
+	notifyHosts(queue, replyText)
 	err = errors.New("no_alert")
 	return
 }
@@ -895,10 +1024,17 @@ func callbackQueryDoneOrSorry(query *tgbotapi.CallbackQuery) (callbackConfig tgb
 	var replyText string
 	var queueID string
 	var action string
+	// 炸岛后选择“我玩好了”时直接结束登岛，不再排下一趟
+	var finished bool
 	if strings.HasPrefix(query.Data, "/done_") {
 		action = "done"
 		queueID = query.Data[6:]
 		replyText = fmt.Sprintf("@%s 满足地表示已经好了", name)
+	} else if strings.HasPrefix(query.Data, "/finish_") {
+		action = "done"
+		finished = true
+		queueID = query.Data[8:]
+		replyText = fmt.Sprintf("@%s 满足地表示已经好了", name)
 	} else if strings.HasPrefix(query.Data, "/sorry_") {
 		action = "sorry"
 		queueID = query.Data[7:]
@@ -934,8 +1070,22 @@ func callbackQueryDoneOrSorry(query *tgbotapi.CallbackQuery) (callbackConfig tgb
 		}, nil
 	}
 
+	if action == "done" {
+		if err = queue.RecordVisit(ctx, client, int64(uid)); err != nil {
+			_logger.Error().Err(err).Msg("record visit duration failed")
+		}
+		if !finished && queue.GuestTrips(int64(uid)) > 1 && queue.IsLanded(int64(uid)) {
+			return callbackQueryNextTrip(ctx, client, query, queue, name)
+		}
+	}
 	if err = queue.Remove(ctx, client, int64(uid)); err != nil {
 		_logger.Error().Err(err).Msg("remove user from queue failed")
+	} else if action == "done" {
+		recordGuestEvent(int64(uid), storage.GuestEventDone)
+		logQueueEvent(queue, storage.QueueEventDone, int64(uid), name)
+	} else {
+		recordGuestEvent(int64(uid), storage.GuestEventSorry)
+		logQueueEvent(queue, storage.QueueEventSorry, int64(uid), name)
 	}
 
 	if queue.IsAuto && queue.MaxGuestCount > 0 && queue.LandedLen() < queue.MaxGuestCount {
@@ -962,38 +1112,7 @@ func callbackQueryDoneOrSorry(query *tgbotapi.CallbackQuery) (callbackConfig tgb
 
 	replyText += fmt.Sprintf("\n队列剩余：%d\n当前在岛：%d", queue.Len(), queue.LandedLen())
 
-	var shareBtn = tgbotapi.NewInlineKeyboardButtonSwitch("分享队列："+queue.Name, "/share_"+queue.ID)
-	var dismissBtn = tgbotapi.NewInlineKeyboardButtonData("解散队列", "/dismiss_"+queue.ID)
-	var listBtn = tgbotapi.NewInlineKeyboardButtonData("查看队列", "/showqueuemember_"+queue.ID)
-	var updatePasswordBtn = tgbotapi.NewInlineKeyboardButtonData("修改密码", "/updatepassword_"+queue.ID)
-	var nextBtn = tgbotapi.NewInlineKeyboardButtonData("有请下一位", "/next_"+queue.ID)
-	var toggleQueueTypeBtnText string
-	if queue.IsAuto {
-		toggleQueueTypeBtnText = "切换为手动队列"
-	} else {
-		toggleQueueTypeBtnText = "切换为自动队列"
-	}
-	var toggleQueueTypeBtn = tgbotapi.NewInlineKeyboardButtonData(toggleQueueTypeBtnText, "/toggle_"+queue.ID)
-	var replyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(shareBtn, dismissBtn),
-		tgbotapi.NewInlineKeyboardRow(listBtn, updatePasswordBtn),
-		tgbotapi.NewInlineKeyboardRow(nextBtn),
-		tgbotapi.NewInlineKeyboardRow(toggleQueueTypeBtn),
-	)
-
-	_, err = tgbot.Send(tgbotapi.MessageConfig{
-		BaseChat: tgbotapi.BaseChat{
-			ChatID:      queue.OwnerID,
-			ReplyMarkup: replyMarkup,
-		},
-		Text: replyText,
-	})
-	if err != nil {
-		_logger.Error().Err(err).Int("uid", uid).
-			Int("msgID", query.Message.MessageID).
-			Str("action", action).
-			Msg("message send failed")
-		return
-	}
+	notifyHosts(queue, replyText)
 	err = errors.New("no_alert")
 	return
 }
@@ -1214,7 +1333,7 @@ func callbackQueryDeleteFriendCode(query *tgbotapi.CallbackQuery) (callbackConfi
 
 func callbackQueryToggleQueue(query *tgbotapi.CallbackQuery) (callbackConfig tgbotapi.CallbackConfig, err error) {
 	queueID := query.Data[8:]
-	uid := query.From.ID
+	uid := int64(query.From.ID)
 	ctx := context.Background()
 	client, err := firestore.NewClient(ctx, _projectID)
 	if err != nil {
@@ -1242,6 +1361,13 @@ func callbackQueryToggleQueue(query *tgbotapi.CallbackQuery) (callbackConfig tgb
 			ShowAlert:       false,
 		}, nil
 	}
+	if !queue.IsHost(uid) {
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "只有岛主才能操作狸",
+			ShowAlert:       false,
+		}, nil
+	}
 	var replyText = "您修改了队列类型，现在队列是："
 	if queue.IsAuto {
 		queue.IsAuto = false
@@ -1266,41 +1392,11 @@ func callbackQueryToggleQueue(query *tgbotapi.CallbackQuery) (callbackConfig tgb
 		_logger.Error().Err(err).Msg("toggle queue type failed")
 		return
 	}
+	logQueueEvent(queue, storage.QueueEventToggle, uid, query.From.UserName)
 
 	replyText += fmt.Sprintf("\n队列剩余：%d\n当前在岛：%d", queue.Len(), queue.LandedLen())
 
-	var shareBtn = tgbotapi.NewInlineKeyboardButtonSwitch("分享队列："+queue.Name, "/share_"+queue.ID)
-	var dismissBtn = tgbotapi.NewInlineKeyboardButtonData("解散队列", "/dismiss_"+queue.ID)
-	var listBtn = tgbotapi.NewInlineKeyboardButtonData("查看队列", "/showqueuemember_"+queue.ID)
-	var updatePasswordBtn = tgbotapi.NewInlineKeyboardButtonData("修改密码", "/updatepassword_"+queue.ID)
-	var nextBtn = tgbotapi.NewInlineKeyboardButtonData("有请下一位", "/next_"+queue.ID)
-	var toggleQueueTypeBtnText string
-	if queue.IsAuto {
-		toggleQueueTypeBtnText = "切换为手动队列"
-	} else {
-		toggleQueueTypeBtnText = "切换为自动队列"
-	}
-	var toggleQueueTypeBtn = tgbotapi.NewInlineKeyboardButtonData(toggleQueueTypeBtnText, "/toggle_"+queue.ID)
-	var replyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(shareBtn, dismissBtn),
-		tgbotapi.NewInlineKeyboardRow(listBtn, updatePasswordBtn),
-		tgbotapi.NewInlineKeyboardRow(nextBtn),
-		tgbotapi.NewInlineKeyboardRow(toggleQueueTypeBtn),
-	)
-
-	_, err = tgbot.Send(tgbotapi.MessageConfig{
-		BaseChat: tgbotapi.BaseChat{
-			ChatID:      queue.OwnerID,
-			ReplyMarkup: replyMarkup,
-		},
-		Text: replyText,
-	})
-	if err != nil {
-		_logger.Error().Err(err).Int("uid", uid).
-			Int("msgID", query.Message.MessageID).
-			Str("action", "toggle queue type").
-			Msg("message send failed")
-		return
-	}
+	notifyHosts(queue, replyText)
 	err = errors.New("no_alert")
 
 	return
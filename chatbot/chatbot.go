@@ -3,6 +3,7 @@ package chatbot
 import (
 	"bufio"
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/url"
 	"sort"
@@ -28,6 +29,9 @@ var (
 	cacheForEdit *lru.Cache
 	sentMsgs     []sentMessage
 	_logger      zerolog.Logger
+
+	// _queueIdleTimeout 队列为空且无人在岛超过此时间后自动解散，0 为不自动解散
+	_queueIdleTimeout time.Duration
 )
 
 type sentMessage struct {
@@ -48,7 +52,7 @@ type ChatBot struct {
 }
 
 // NewChatBot return new chat bot
-func NewChatBot(token, domain, appID, projectID, port string, adminID int) ChatBot {
+func NewChatBot(token, domain, appID, projectID, port string, adminID, queueIdleMinutes int) ChatBot {
 	var logger zerolog.Logger
 	sw, err := stackdriverhook.NewStackdriverLoggingWriter(projectID, "nsfcbot", map[string]string{"from": "telegrambot"})
 	if err != nil {
@@ -79,8 +83,10 @@ func NewChatBot(token, domain, appID, projectID, port string, adminID int) ChatB
 	/open 开放自己的岛 命令后可以附上岛屿今日特色内容
 	/close 关闭自己的岛
 	/dtcj 更新大头菜价格, 不带参数时，和 /gj 相同
+	/sellqueue 设置菜价，/dtcj 报价达到此菜价时提示一键开启卖菜队列
 	/weekprice 当周菜价回看/预测
 	/gj 大头菜最新价格，通常只显示同群中价格从高到低前5名
+	/forecast 根据本周报价，预测本群接下来最可能出现高价的岛屿
 	/islands 提供网页展示本bot 记录的所有动森岛屿信息
 	/login 登录到本bot 的web 界面，更方便查看信息
 	/comment 对 @NS_FC_bot 提建议
@@ -129,7 +135,7 @@ func NewChatBot(token, domain, appID, projectID, port string, adminID int) ChatB
 		}
 	}
 
-	router := NewRouter()
+	router := NewRouter(RegisterGroup, LogCommand)
 	botAdminID = adminID
 	_projectID = projectID
 	_domain = domain
@@ -146,14 +152,17 @@ func NewChatBot(token, domain, appID, projectID, port string, adminID int) ChatB
 	})
 
 	// 仅占位用
-	router.HandleFunc("start", cmdStart)
+	router.HandleFunc("start", cmdStart, PrivateOnly)
+
+	// 群里的查询命令，每人每分钟最多 10 次
+	searchRateLimit := RateLimit(10, time.Minute)
 
 	router.HandleFunc("addfc", cmdAddFC)
 	router.HandleFunc("delfc", cmdDelFC)
 	router.HandleFunc("myfc", cmdMyFC)
-	router.HandleFunc("sfc", cmdSearchFC)
-	router.HandleFunc("fc", cmdSearchFC)
-	router.HandleFunc("fclist", cmdListFriendCodes)
+	router.HandleFunc("sfc", cmdSearchFC, GroupOnly, searchRateLimit)
+	router.HandleFunc("fc", cmdSearchFC, GroupOnly, searchRateLimit)
+	router.HandleFunc("fclist", cmdListFriendCodes, GroupOnly, searchRateLimit)
 	//router.HandleFunc("deleteme", cmdDeleteMe)
 	router.HandleFunc("comment", cmdComments)
 	router.HandleFunc("donate", cmdDonate)
@@ -168,27 +177,33 @@ func NewChatBot(token, domain, appID, projectID, port string, adminID int) ChatB
 	router.HandleFunc("open", cmdOpenIsland)
 	router.HandleFunc("close", cmdCloseIsland)
 	router.HandleFunc("dtcj", cmdDTCPriceUpdate)
+	router.HandleFunc("sellqueue", cmdSetSellQueuePrice)
 	router.HandleFunc("weekprice", cmdDTCWeekPriceAndPredict)
-	router.HandleFunc("gj", cmdDTCMaxPriceInGroup)
-	router.HandleFunc("sac", cmdSearchAnimalCrossingInfo)
+	router.HandleFunc("gj", cmdDTCMaxPriceInGroup, searchRateLimit)
+	router.HandleFunc("forecast", cmdDTCForecastInGroup, GroupOnly, searchRateLimit)
+	router.HandleFunc("sac", cmdSearchAnimalCrossingInfo, GroupOnly, searchRateLimit)
 	router.HandleFunc("ghs", cmdHuaShiJiaoHuanBiaoGe)
-	router.HandleFunc("whois", cmdWhois)
+	router.HandleFunc("whois", cmdWhois, GroupOnly, searchRateLimit)
 
 	// queue
 	router.HandleFunc("queue", cmdOpenIslandQueue)
-	router.HandleFunc("myqueue", cmdMyQueue)
-	router.HandleFunc("list", cmdJoinedQueue)
+	router.HandleFunc("myqueue", cmdMyQueue, PrivateOnly)
+	router.HandleFunc("list", cmdJoinedQueue, PrivateOnly)
 	router.HandleFunc("dismiss", cmdDismissIslandQueue)
+	router.HandleFunc("queueset", cmdQueueSettings, PrivateOnly)
+	router.HandleFunc("queuetemplate", cmdQueueTemplate, PrivateOnly)
+	router.HandleFunc("banlist", cmdBanList, PrivateOnly)
 
 	// web login
 	router.HandleFunc("login", cmdWebLogin)
 
 	// admin
-	router.HandleFunc("importDATA", cmdImportData)
-	router.HandleFunc("updatetimezone", cmdUpgradeData)
-	router.HandleFunc("fclistall", cmdListAllFriendCodes)
-	router.HandleFunc("debug", cmdToggleDebugMode)
-	router.HandleFunc("clear", c.cmdClearMessages)
+	router.HandleFunc("importDATA", cmdImportData, AdminOnly)
+	router.HandleFunc("updatetimezone", cmdUpgradeData, AdminOnly)
+	router.HandleFunc("migratequeues", cmdMigrateQueues, AdminOnly)
+	router.HandleFunc("fclistall", cmdListAllFriendCodes, AdminOnly)
+	router.HandleFunc("debug", cmdToggleDebugMode, AdminOnly)
+	router.HandleFunc("clear", c.cmdClearMessages, AdminOnly)
 
 	logger.Info().Str("bot username", bot.Self.UserName).
 		Int("bot id", bot.Self.ID).Msg("authorized success")
@@ -199,6 +214,8 @@ func NewChatBot(token, domain, appID, projectID, port string, adminID int) ChatB
 	if cacheForEdit, err = lru.New(17); err != nil {
 		logger.Error().Err(err).Msg("new lru cache failed")
 	}
+	_queueIdleTimeout = time.Duration(queueIdleMinutes) * time.Minute
+	storage.OnQueueDismissed = finishDismissedQueue
 
 	return c
 }
@@ -208,21 +225,53 @@ func (c ChatBot) Close() {
 	c.logwriter.Close()
 }
 
+// webhookAllowedUpdates 需要 Telegram 推送的更新类型
+var webhookAllowedUpdates = []string{"message", "edited_message", "inline_query", "chosen_inline_result", "callback_query"}
+
+// webhookInfo tgbotapi.WebhookInfo 没有 allowed_updates
+type webhookInfo struct {
+	tgbotapi.WebhookInfo
+	AllowedUpdates []string `json:"allowed_updates"`
+}
+
+// needUpdate 已设置的 webhook 缺少需要的更新类型时，需要重新设置
+func (info webhookInfo) needUpdate() bool {
+	var allowed = make(map[string]bool)
+	for _, u := range info.AllowedUpdates {
+		allowed[u] = true
+	}
+	for _, u := range webhookAllowedUpdates {
+		if !allowed[u] {
+			return true
+		}
+	}
+	return false
+}
+
+func (c ChatBot) getWebhookInfo() (info webhookInfo, err error) {
+	resp, err := c.TgBotClient.MakeRequest("getWebhookInfo", url.Values{})
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(resp.Result, &info)
+	return
+}
+
 // SetWebhook set webhook
 func (c ChatBot) SetWebhook() (err error) {
-	info, err := c.TgBotClient.GetWebhookInfo()
+	info, err := c.getWebhookInfo()
 	if err != nil {
 		return
 	}
 	if info.LastErrorDate != 0 {
 		c.logger.Info().Str("last error message", info.LastErrorMessage).Msg("Telegram callback failed")
 	}
-	if !info.IsSet() {
+	if !info.IsSet() || info.needUpdate() {
 		var webhookConfig WebhookConfig
 		var wc = tgbotapi.NewWebhook(fmt.Sprintf("https://%s.appspot.com/%s", c.appID, c.token))
 		webhookConfig = WebhookConfig{WebhookConfig: wc}
 		webhookConfig.MaxConnections = 20
-		webhookConfig.AllowedUpdates = []string{"message", "edited_message", "inline_query", "callback_query"}
+		webhookConfig.AllowedUpdates = webhookAllowedUpdates
 		var apiResp tgbotapi.APIResponse
 		apiResp, err = c.setWebhook(webhookConfig)
 		if err != nil {
@@ -254,6 +303,7 @@ func (c ChatBot) MessageHandler(updates chan tgbotapi.Update) {
 func (c ChatBot) messageHandlerWorker(updates chan tgbotapi.Update) {
 	for update := range updates {
 		inlineQuery := update.InlineQuery
+		chosenInlineResult := update.ChosenInlineResult
 		callbackQuery := update.CallbackQuery
 		message := update.Message
 		var isEditedMessage bool = false
@@ -265,21 +315,14 @@ func (c ChatBot) messageHandlerWorker(updates chan tgbotapi.Update) {
 		}
 		if inlineQuery != nil {
 			c.HandleInlineQuery(inlineQuery)
+		} else if chosenInlineResult != nil {
+			c.HandleChosenInlineResult(chosenInlineResult)
 		} else if callbackQuery != nil {
 			c.HandleCallbackQuery(callbackQuery)
 		} else if message != nil && message.From.IsBot {
 			continue
-		} else if message != nil && message.Chat.IsPrivate() && !message.IsCommand() && message.ReplyToMessage != nil && message.ReplyToMessage.Text == "请输入新的密码" && message.ReplyToMessage.From.IsBot {
-			replies, e := cmdUpdatePassword(message)
-			if e != nil {
-				c.logger.Error().Err(e).Msg("cmdUpdatePassword")
-			}
-			for _, reply := range replies {
-				_, e := c.TgBotClient.Send(reply)
-				if e != nil {
-					c.logger.Error().Err(e).Msg("cmdUpdatePassword send message")
-				}
-			}
+		} else if message != nil && message.Chat.IsPrivate() && !message.IsCommand() && message.ReplyToMessage != nil && message.ReplyToMessage.From.IsBot {
+			c.HandleForceReply(message)
 		} else if message != nil && message.IsCommand() {
 			if message.Chat.IsGroup() || message.Chat.IsSuperGroup() || message.Chat.IsPrivate() {
 				if len(sentMsgs) > 0 {
@@ -510,8 +553,55 @@ func (c ChatBot) messageHandlerWorker(updates chan tgbotapi.Update) {
 					}
 				}
 			}
+		} else if message != nil && message.Chat.IsPrivate() && !message.IsCommand() && !isEditedMessage {
+			c.HandleRelayMessage(message)
 		} else if message != nil {
 			_logger.Debug().Str("text", message.Text).Msg("recv new message")
+			recordQueueShare(message)
+		}
+	}
+}
+
+// HandleForceReply handle replies to bot's ForceReply prompts
+func (c ChatBot) HandleForceReply(message *tgbotapi.Message) {
+	var handler func(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig, err error)
+	var name string
+	promptText := message.ReplyToMessage.Text
+	if strings.HasPrefix(promptText, updatePasswordPrompt) || promptText == "请输入新的密码" {
+		handler, name = cmdUpdatePassword, "cmdUpdatePassword"
+	} else if strings.HasPrefix(promptText, sellQueuePasswordPrompt) {
+		handler, name = cmdOpenSellQueue, "cmdOpenSellQueue"
+	} else if strings.HasPrefix(promptText, kickReasonPrompt) {
+		handler, name = cmdSendKickReason, "cmdSendKickReason"
+	} else if strings.HasPrefix(promptText, queueSchedulePasswordPrompt) {
+		handler, name = cmdOpenScheduledQueue, "cmdOpenScheduledQueue"
+	} else if strings.HasPrefix(promptText, relayGuestPrompt) {
+		handler, name = cmdRelayToGuest, "cmdRelayToGuest"
+	} else if strings.HasPrefix(promptText, relayHostPrompt) {
+		handler, name = cmdRelayToHost, "cmdRelayToHost"
+	} else if strings.HasPrefix(promptText, islandCrashPasswordPrompt) {
+		handler, name = cmdIslandCrashed, "cmdIslandCrashed"
+	} else if strings.HasPrefix(promptText, queueBroadcastPrompt) {
+		handler, name = cmdQueueBroadcast, "cmdQueueBroadcast"
+	} else if strings.HasPrefix(promptText, queueTemplatePasswordPrompt) {
+		handler, name = cmdOpenQueueFromTemplate, "cmdOpenQueueFromTemplate"
+	} else {
+		_logger.Debug().Str("text", message.Text).Msg("recv reply message")
+		// 在岛客人回复邀请消息等，也转达给岛主
+		c.HandleRelayMessage(message)
+		return
+	}
+	replies, err := handler(message)
+	if err != nil {
+		c.logger.Error().Err(err).Msg(name)
+		if e, ok := err.(Error); ok && len(e.ReplyText) > 0 {
+			replies = append(replies, tgbotapi.NewMessage(message.Chat.ID, e.ReplyText))
+		}
+	}
+	for _, reply := range replies {
+		_, err := c.TgBotClient.Send(reply)
+		if err != nil {
+			c.logger.Error().Err(err).Msg(name + " send message")
 		}
 	}
 }
@@ -529,14 +619,23 @@ type WebhookConfig struct {
 // If you do not have a legitimate TLS certificate, you need to include
 // your self signed certificate with the config.
 func (c ChatBot) setWebhook(config WebhookConfig) (tgbotapi.APIResponse, error) {
+	// Telegram 要求 allowed_updates 是 JSON 数组
+	var allowedUpdates string
+	if len(config.AllowedUpdates) != 0 {
+		b, err := json.Marshal(config.AllowedUpdates)
+		if err != nil {
+			return tgbotapi.APIResponse{}, err
+		}
+		allowedUpdates = string(b)
+	}
 	if config.Certificate == nil {
 		v := url.Values{}
 		v.Add("url", config.URL.String())
 		if config.MaxConnections != 0 {
 			v.Add("max_connections", strconv.Itoa(config.MaxConnections))
 		}
-		if len(config.AllowedUpdates) != 0 {
-			v["allowed_updates"] = config.AllowedUpdates
+		if len(allowedUpdates) != 0 {
+			v.Add("allowed_updates", allowedUpdates)
 		}
 
 		return c.TgBotClient.MakeRequest("setWebhook", v)
@@ -547,6 +646,9 @@ func (c ChatBot) setWebhook(config WebhookConfig) (tgbotapi.APIResponse, error)
 	if config.MaxConnections != 0 {
 		params["max_connections"] = strconv.Itoa(config.MaxConnections)
 	}
+	if len(allowedUpdates) != 0 {
+		params["allowed_updates"] = allowedUpdates
+	}
 
 	resp, err := c.TgBotClient.UploadFile("setWebhook", params, "certificate", config.Certificate)
 	if err != nil {
@@ -1,17 +1,13 @@
 package chatbot
 
 import (
-	"context"
 	"errors"
 	"fmt"
 	"sort"
 	"strings"
 
 	fuzzy "github.com/doylecnn/go-fuzzywuzzy"
-	"github.com/doylecnn/new-nsfc-bot/storage"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
 var (
@@ -26,6 +22,9 @@ type Error struct {
 }
 
 func (e Error) Error() string {
+	if e.InnerError == nil {
+		return e.ReplyText
+	}
 	return e.InnerError.Error()
 }
 
@@ -34,26 +33,42 @@ type Command interface {
 	Do(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig, err error)
 }
 
+// HandlerFunc 命令处理函数
+type HandlerFunc func(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig, err error)
+
+// Middleware 包装命令处理函数，cmd 为命令名，可以在 next 执行前拦截或者在执行后处理结果
+type Middleware func(cmd string, next HandlerFunc) HandlerFunc
+
 // Router is command router
 type Router struct {
-	commands       map[string]func(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig, err error)
-	commandSuggest func(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig, err error)
+	commands       map[string]HandlerFunc
+	commandSuggest HandlerFunc
+	middlewares    []Middleware
 }
 
-// NewRouter returns new Router
-func NewRouter() Router {
+// NewRouter returns new Router, middlewares 会按顺序包装所有命令
+func NewRouter(middlewares ...Middleware) Router {
 	r := Router{}
-	r.commands = make(map[string]func(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig, err error))
+	r.commands = make(map[string]HandlerFunc)
 	r.commandSuggest = cmdSuggest
+	r.middlewares = middlewares
 	return r
 }
 
-// HandleFunc regist HandleFunc
-func (r Router) HandleFunc(cmd string, f func(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig, err error)) {
+// HandleFunc regist HandleFunc, middlewares 只作用于这个命令，在 Router 的 middlewares 之后执行
+func (r Router) HandleFunc(cmd string, f HandlerFunc, middlewares ...Middleware) {
 	if _, ok := r.commands[cmd]; ok {
 		_logger.Fatal().Err(errors.New("already exists handle func")).Send()
 	}
-	r.commands[cmd] = f
+	r.commands[cmd] = chainMiddlewares(cmd, f, middlewares)
+}
+
+// chainMiddlewares 用 middlewares 包装 f，第一个 middleware 最先执行
+func chainMiddlewares(cmd string, f HandlerFunc, middlewares []Middleware) HandlerFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		f = middlewares[i](cmd, f)
+	}
+	return f
 }
 
 // Run the command
@@ -61,63 +76,31 @@ func (r Router) Run(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageC
 	if !message.IsCommand() {
 		return
 	}
-	ctx := context.Background()
-	var groupID int64 = 0
-	if !message.Chat.IsPrivate() {
-		groupID = message.Chat.ID
-	}
-	if groupID != 0 {
-		if lerr := storage.AddGroupIDToUserGroupIDs(ctx, message.From.ID, groupID); lerr != nil {
-			_logger.Error().Err(lerr).Msg("add groupid to user's groupids failed")
-		}
-		g, err := storage.GetGroup(ctx, groupID)
-		if err != nil && status.Code(err) != codes.NotFound {
-			_logger.Error().Err(err).Msg("GetGroupError")
-		} else if err != nil && status.Code(err) == codes.NotFound {
-			g = storage.Group{ID: message.Chat.ID, Type: message.Chat.Type, Title: message.Chat.Title}
-			g.Set(ctx)
-		} else {
-			if g.Title != message.Chat.Title || g.Type != message.Chat.Type {
-				g.Type = message.Chat.Type
-				g.Title = message.Chat.Title
-				g.Update(ctx)
-			}
-		}
-	}
-	_logger.Info().Str("command", message.Command()).
-		Str("args", message.CommandArguments()).
-		Time("receive datetime", message.Time()).
-		Int("UID", message.From.ID).
-		Int64("ChatID", message.Chat.ID).
-		Str("FromUser", message.From.UserName).
-		Msg("receive command")
 	cmd := message.Command()
-	if c, ok := r.commands[cmd]; ok {
-		replies, e := c(message)
-		if e != nil {
-			if e, ok := e.(Error); ok {
-				if e.InnerError != nil {
-					return nil, &Error{InnerError: fmt.Errorf("error occurred when running cmd: %s: +inner error is: %w", cmd, e.InnerError), ReplyText: e.ReplyText}
-				}
-				return nil, &Error{InnerError: fmt.Errorf("error occurred when running cmd: %s: -inner error is: %w", cmd, e), ReplyText: e.ReplyText}
-			}
-			return nil, &Error{InnerError: fmt.Errorf("error occurred when running cmd: %s: error is: %w", cmd, e)}
+	name := cmd
+	handler, ok := r.commands[cmd]
+	if !ok {
+		if r.commandSuggest == nil {
+			return nil, &Error{InnerError: fmt.Errorf("no HandleFunc for command /%s", cmd)}
 		}
-		return replies, nil
-	} else if r.commandSuggest != nil {
-		replies, e := r.commandSuggest(message)
-		if e != nil {
-			if e, ok := e.(Error); ok {
-				if e.InnerError != nil {
-					return nil, &Error{InnerError: fmt.Errorf("error occurred when running cmd: /suggest: +inner error is: %w", e.InnerError), ReplyText: e.ReplyText}
-				}
-				return nil, &Error{InnerError: fmt.Errorf("error occurred when running cmd: /suggest: -inner error is: %w", e), ReplyText: e.ReplyText}
-			}
-			return nil, &Error{InnerError: fmt.Errorf("error occurred when running cmd: /suggest: error is: %w", e)}
+		name, handler = "/suggest", r.commandSuggest
+	}
+	replies, e := chainMiddlewares(cmd, handler, r.middlewares)(message)
+	if e != nil {
+		return nil, wrapCommandError(name, e)
+	}
+	return replies, nil
+}
+
+// wrapCommandError 在错误中加上命令名，保留给用户的回复
+func wrapCommandError(cmd string, e error) *Error {
+	if e, ok := e.(Error); ok {
+		if e.InnerError != nil {
+			return &Error{InnerError: fmt.Errorf("error occurred when running cmd: %s: +inner error is: %w", cmd, e.InnerError), ReplyText: e.ReplyText}
 		}
-		return replies, nil
+		return &Error{InnerError: fmt.Errorf("error occurred when running cmd: %s: -inner error is: %w", cmd, e), ReplyText: e.ReplyText}
 	}
-	return nil, &Error{InnerError: fmt.Errorf("no HandleFunc for command /%s", cmd)}
+	return &Error{InnerError: fmt.Errorf("error occurred when running cmd: %s: error is: %w", cmd, e)}
 }
 
 func cmdSuggest(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig, err error) {
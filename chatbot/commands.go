@@ -242,9 +242,6 @@ func cmdMyFC(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig,
 }
 
 func cmdSearchFC(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig, err error) {
-	if message.Chat.IsPrivate() {
-		return
-	}
 	args := strings.TrimSpace(message.CommandArguments())
 	ctx := context.Background()
 	var us []storage.User
@@ -368,9 +365,6 @@ func cmdWebLogin(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConf
 }
 
 func cmdListFriendCodes(message *tgbotapi.Message) (replyMessages []tgbotapi.MessageConfig, err error) {
-	if message.Chat.IsPrivate() {
-		return
-	}
 	ctx := context.Background()
 	users, err := storage.GetGroupUsers(ctx, message.Chat.ID)
 	if err != nil {
@@ -0,0 +1,84 @@
+package chatbot
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// dodoCodeLength Dodo Airlines 提供的密码长度
+const dodoCodeLength = 5
+
+// dodoCodeConfusables 游戏中的密码不会出现字母 O 和 I，输入时多半是数字 0 和 1
+var dodoCodeConfusables = map[rune]rune{
+	'O': '0',
+	'I': '1',
+}
+
+// normalizeDodoCodeRune 全角转半角，小写转大写
+func normalizeDodoCodeRune(r rune) rune {
+	if r >= '！' && r <= '～' {
+		r -= '！' - '!'
+	} else if r == '　' {
+		r = ' '
+	}
+	return unicode.ToUpper(r)
+}
+
+func isDodoCodeRune(r rune) bool {
+	return (r >= '0' && r <= '9') || (r >= 'A' && r <= 'Z')
+}
+
+// parseDodoCode 从岛主输入的文字中解析出 5 位密码，可以是“密码是 AB12C”这样的句子；
+// notice 为对易混淆字符的提示，解析失败时 err 为 Error，ReplyText 为给岛主的提示
+func parseDodoCode(text string) (code, notice string, err error) {
+	normalized := []rune(strings.TrimSpace(strings.Map(normalizeDodoCodeRune, text)))
+	var candidates [][]rune
+	if len(normalized) == dodoCodeLength {
+		// 整条消息就是密码时，逐个字符检查
+		for _, r := range normalized {
+			if !isDodoCodeRune(r) {
+				return "", "", Error{ReplyText: fmt.Sprintf("密码中不会出现“%c”，密码只包含字母和数字，一定有 %d 位", r, dodoCodeLength)}
+			}
+		}
+		candidates = append(candidates, normalized)
+	} else {
+		var run []rune
+		for _, r := range append(normalized, ' ') {
+			if isDodoCodeRune(r) {
+				run = append(run, r)
+				continue
+			}
+			if len(run) == dodoCodeLength {
+				candidates = append(candidates, run)
+			}
+			run = nil
+		}
+	}
+	if len(candidates) == 0 {
+		return "", "", Error{ReplyText: fmt.Sprintf("没有找到密码狸，请输入 Dodo Airlines 工作人员 莫里（Orville）提供的 %d 位密码", dodoCodeLength)}
+	}
+	if len(candidates) > 1 {
+		return "", "", Error{ReplyText: "找到了多个像是密码的内容狸，请只输入密码"}
+	}
+	var replaced []string
+	c := candidates[0]
+	for i, r := range c {
+		if d, ok := dodoCodeConfusables[r]; ok {
+			c[i] = d
+			replaced = append(replaced, fmt.Sprintf("字母 %c 已识别为数字 %c", r, d))
+		}
+	}
+	if len(replaced) > 0 {
+		notice = fmt.Sprintf("注意：%s，请核对密码是否为 %s", strings.Join(replaced, "，"), string(c))
+	}
+	return string(c), notice, nil
+}
+
+// dodoCodeText 回复给岛主的密码，附带易混淆字符的提示
+func dodoCodeText(code, notice string) string {
+	if len(notice) == 0 {
+		return code
+	}
+	return code + "\n" + notice
+}
@@ -0,0 +1,43 @@
+package chatbot
+
+import "testing"
+
+func TestParseDodoCode(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		code   string
+		notice string
+		reply  string
+	}{
+		{"plain", "AB12C", "AB12C", "", ""},
+		{"sentence", "密码是 AB12C", "AB12C", "", ""},
+		{"lowercase", "ab12c", "AB12C", "", ""},
+		{"full width", "ＡＢ１２Ｃ", "AB12C", "", ""},
+		{"full width sentence", "密码是　ＡＢ１２Ｃ", "AB12C", "", ""},
+		{"O to 0", "AB1OC", "AB10C", "注意：字母 O 已识别为数字 0，请核对密码是否为 AB10C", ""},
+		{"O and I", "密码 OI23C", "0123C", "注意：字母 O 已识别为数字 0，字母 I 已识别为数字 1，请核对密码是否为 0123C", ""},
+		{"two candidates", "AB12C 或者 XY34Z", "", "", "找到了多个像是密码的内容狸，请只输入密码"},
+		{"invalid character", "AB-2C", "", "", "密码中不会出现“-”，密码只包含字母和数字，一定有 5 位"},
+		{"too short", "密码是 AB12", "", "", "没有找到密码狸，请输入 Dodo Airlines 工作人员 莫里（Orville）提供的 5 位密码"},
+	}
+	for _, tt := range tests {
+		code, notice, err := parseDodoCode(tt.text)
+		if len(tt.reply) > 0 {
+			if e, ok := err.(Error); !ok || e.ReplyText != tt.reply {
+				t.Errorf("%s: err = %v, want %q", tt.name, err, tt.reply)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if code != tt.code {
+			t.Errorf("%s: code = %q, want %q", tt.name, code, tt.code)
+		}
+		if notice != tt.notice {
+			t.Errorf("%s: notice = %q, want %q", tt.name, notice, tt.notice)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package chatbot
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/doylecnn/new-nsfc-bot/storage"
+)
+
+// reliableGuestWindow 开启“仅限可靠客人”时，此时间内有超时未到记录的客人不能加入
+const reliableGuestWindow = 7 * 24 * time.Hour
+
+// recordGuestEvent 记录客人在队列中的结局
+func recordGuestEvent(uid int64, event string) {
+	if err := storage.RecordGuestEvent(context.Background(), uid, event); err != nil {
+		_logger.Error().Err(err).Int64("uid", uid).Str("event", event).Msg("record guest event failed")
+	}
+}
+
+// reputationSummary 客人登岛记录的简短说明
+func reputationSummary(r storage.GuestReputation, ok bool) string {
+	if !ok {
+		return "（新客人）"
+	}
+	summary := fmt.Sprintf("（完成 %d / 取消 %d / 超时 %d / 被移出 %d", r.Done, r.Sorry, r.Timeout, r.Kicked)
+	if r.HasNoShowSince(time.Now().Add(-reliableGuestWindow)) {
+		summary += " / 近期有超时"
+	}
+	return summary + "）"
+}
+
+// isReliableGuest 队列开启“仅限可靠客人”时，检查客人近期是否有超时未到的记录
+func isReliableGuest(queue *storage.OnboardQueue, uid int64) bool {
+	if !queue.OnlyReliableGuests {
+		return true
+	}
+	reputations, err := storage.GetGuestReputations(context.Background(), []int64{uid})
+	if err != nil {
+		_logger.Error().Err(err).Int64("uid", uid).Msg("isReliableGuest GetGuestReputations")
+		return true
+	}
+	r, ok := reputations[uid]
+	return !ok || !r.HasNoShowSince(time.Now().Add(-reliableGuestWindow))
+}
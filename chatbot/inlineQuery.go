@@ -33,6 +33,24 @@ func (c ChatBot) HandleInlineQuery(inlineQuery *tgbotapi.InlineQuery) {
 				_logger.Error().Err(err).Msg("answer share queue inline query failed")
 			}
 		}
+	} else if strings.HasPrefix(inlineQuery.Query, "/shareschedule_") {
+		if result, err := inlineQueryShareSchedule(inlineQuery); err != nil {
+			_logger.Warn().Err(err).Send()
+		} else {
+			_, err := c.TgBotClient.AnswerInlineQuery(*result)
+			if err != nil {
+				_logger.Error().Err(err).Msg("answer share schedule inline query failed")
+			}
+		}
+	}
+}
+
+// HandleChosenInlineResult handle inline results chosen by user
+func (c ChatBot) HandleChosenInlineResult(result *tgbotapi.ChosenInlineResult) {
+	if strings.HasPrefix(result.Query, "/share_") {
+		if err := chosenInlineResultShareQueue(result); err != nil {
+			_logger.Warn().Err(err).Str("query", result.Query).Msg("record shared queue card failed")
+		}
 	}
 }
 
@@ -47,9 +65,13 @@ func inlineQueryShareQueue(query *tgbotapi.InlineQuery) (rst *tgbotapi.InlineCon
 	if island.OnBoardQueueID != queueID {
 		return nil, errors.New("not island owner")
 	}
-	r := tgbotapi.NewInlineQueryResultArticle(query.ID, fmt.Sprintf("分享前往您的岛屿 %s 的队列", island.Name), fmt.Sprintf("邀请您加入前往 %s 的队列\n本次信息：%s\n点击“加入队列”按钮后，请再点击“start”按钮", island.Name, island.Info))
-	var joinBtn = tgbotapi.NewInlineKeyboardButtonURL("加入队列", "https://t.me/NS_FC_bot?start=join_"+queueID)
-	var replyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(joinBtn))
+	queue, err := island.GetOnboardQueue(ctx)
+	if err != nil {
+		return
+	}
+	text, joinText := queueCardText(queue, island)
+	r := tgbotapi.NewInlineQueryResultArticle(query.ID, fmt.Sprintf("分享前往您的岛屿 %s 的队列", island.Name), text)
+	var replyMarkup = queueCardReplyMarkup(queue, joinText)
 	r.ReplyMarkup = &replyMarkup
 	return &tgbotapi.InlineConfig{
 		InlineQueryID: query.ID,
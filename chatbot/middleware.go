@@ -0,0 +1,117 @@
+package chatbot
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/doylecnn/new-nsfc-bot/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// RegisterGroup 群里的命令：记录用户所在的群，并保存/更新群的信息
+func RegisterGroup(cmd string, next HandlerFunc) HandlerFunc {
+	return func(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig, err error) {
+		if message.Chat.IsPrivate() {
+			return next(message)
+		}
+		ctx := context.Background()
+		groupID := message.Chat.ID
+		if lerr := storage.AddGroupIDToUserGroupIDs(ctx, message.From.ID, groupID); lerr != nil {
+			_logger.Error().Err(lerr).Msg("add groupid to user's groupids failed")
+		}
+		g, gerr := storage.GetGroup(ctx, groupID)
+		if gerr != nil && status.Code(gerr) != codes.NotFound {
+			_logger.Error().Err(gerr).Msg("GetGroupError")
+		} else if gerr != nil && status.Code(gerr) == codes.NotFound {
+			g = storage.Group{ID: message.Chat.ID, Type: message.Chat.Type, Title: message.Chat.Title}
+			g.Set(ctx)
+		} else {
+			if g.Title != message.Chat.Title || g.Type != message.Chat.Type {
+				g.Type = message.Chat.Type
+				g.Title = message.Chat.Title
+				g.Update(ctx)
+			}
+		}
+		return next(message)
+	}
+}
+
+// LogCommand 记录收到的命令
+func LogCommand(cmd string, next HandlerFunc) HandlerFunc {
+	return func(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig, err error) {
+		_logger.Info().Str("command", message.Command()).
+			Str("args", message.CommandArguments()).
+			Time("receive datetime", message.Time()).
+			Int("UID", message.From.ID).
+			Int64("ChatID", message.Chat.ID).
+			Str("FromUser", message.From.UserName).
+			Msg("receive command")
+		return next(message)
+	}
+}
+
+// PrivateOnly 命令只能私聊使用，在群里忽略
+func PrivateOnly(cmd string, next HandlerFunc) HandlerFunc {
+	return func(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig, err error) {
+		if !message.Chat.IsPrivate() {
+			return
+		}
+		return next(message)
+	}
+}
+
+// GroupOnly 命令只能在群里使用，私聊时忽略
+func GroupOnly(cmd string, next HandlerFunc) HandlerFunc {
+	return func(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig, err error) {
+		if message.Chat.IsPrivate() {
+			return
+		}
+		return next(message)
+	}
+}
+
+// AdminOnly 命令只有 bot 管理员可以使用，其他人忽略
+func AdminOnly(cmd string, next HandlerFunc) HandlerFunc {
+	return func(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig, err error) {
+		if message.From.ID != botAdminID {
+			return
+		}
+		return next(message)
+	}
+}
+
+// RateLimit 每个用户在 per 时间内最多使用 n 次该命令，超过时提示稍后再试
+func RateLimit(n int, per time.Duration) Middleware {
+	var mu sync.Mutex
+	var history = make(map[int][]time.Time)
+	return func(cmd string, next HandlerFunc) HandlerFunc {
+		return func(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig, err error) {
+			now := time.Now()
+			mu.Lock()
+			var recent []time.Time
+			for _, t := range history[message.From.ID] {
+				if now.Sub(t) < per {
+					recent = append(recent, t)
+				}
+			}
+			limited := len(recent) >= n
+			if !limited {
+				recent = append(recent, now)
+			}
+			if len(recent) > 0 {
+				history[message.From.ID] = recent
+			} else {
+				delete(history, message.From.ID)
+			}
+			mu.Unlock()
+			if limited {
+				return nil, Error{ReplyText: "操作太频繁了狸，请稍后再试"}
+			}
+			return next(message)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package chatbot
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+func testCommandMessage(uid int, chatType string, text string) *tgbotapi.Message {
+	return &tgbotapi.Message{
+		From:     &tgbotapi.User{ID: uid},
+		Chat:     &tgbotapi.Chat{ID: int64(uid), Type: chatType},
+		Text:     text,
+		Entities: &[]tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(text)}},
+	}
+}
+
+// recordMiddleware 记录 middleware 的执行顺序
+func recordMiddleware(name string, calls *[]string) Middleware {
+	return func(cmd string, next HandlerFunc) HandlerFunc {
+		return func(message *tgbotapi.Message) ([]tgbotapi.MessageConfig, error) {
+			*calls = append(*calls, name+":"+cmd)
+			return next(message)
+		}
+	}
+}
+
+func TestChainMiddlewaresOrder(t *testing.T) {
+	var calls []string
+	f := chainMiddlewares("ping", func(message *tgbotapi.Message) ([]tgbotapi.MessageConfig, error) {
+		calls = append(calls, "handler")
+		return nil, nil
+	}, []Middleware{recordMiddleware("a", &calls), recordMiddleware("b", &calls)})
+	if _, err := f(testCommandMessage(1, "private", "/ping")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"a:ping", "b:ping", "handler"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+}
+
+func TestRouterMiddlewaresOrder(t *testing.T) {
+	var calls []string
+	router := NewRouter(recordMiddleware("router", &calls))
+	router.HandleFunc("ping", func(message *tgbotapi.Message) ([]tgbotapi.MessageConfig, error) {
+		calls = append(calls, "handler")
+		return nil, nil
+	}, recordMiddleware("cmd", &calls))
+	if _, err := router.Run(testCommandMessage(1, "private", "/ping")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"router:ping", "cmd:ping", "handler"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+}
+
+func TestChatTypeMiddlewares(t *testing.T) {
+	defer func(id int) { botAdminID = id }(botAdminID)
+	botAdminID = 42
+	tests := []struct {
+		name       string
+		middleware Middleware
+		message    *tgbotapi.Message
+		called     bool
+	}{
+		{"PrivateOnly private", PrivateOnly, testCommandMessage(1, "private", "/ping"), true},
+		{"PrivateOnly group", PrivateOnly, testCommandMessage(1, "group", "/ping"), false},
+		{"GroupOnly private", GroupOnly, testCommandMessage(1, "private", "/ping"), false},
+		{"GroupOnly supergroup", GroupOnly, testCommandMessage(1, "supergroup", "/ping"), true},
+		{"AdminOnly admin", AdminOnly, testCommandMessage(42, "private", "/ping"), true},
+		{"AdminOnly other", AdminOnly, testCommandMessage(1, "private", "/ping"), false},
+	}
+	for _, tt := range tests {
+		var called bool
+		f := tt.middleware("ping", func(message *tgbotapi.Message) ([]tgbotapi.MessageConfig, error) {
+			called = true
+			return nil, nil
+		})
+		if _, err := f(tt.message); err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		}
+		if called != tt.called {
+			t.Errorf("%s: called = %v, want %v", tt.name, called, tt.called)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	var count int
+	f := RateLimit(2, 50*time.Millisecond)("ping", func(message *tgbotapi.Message) ([]tgbotapi.MessageConfig, error) {
+		count++
+		return nil, nil
+	})
+	for i := 0; i < 2; i++ {
+		if _, err := f(testCommandMessage(1, "private", "/ping")); err != nil {
+			t.Fatalf("call %d: unexpected error: %v", i+1, err)
+		}
+	}
+	_, err := f(testCommandMessage(1, "private", "/ping"))
+	if e, ok := err.(Error); !ok || e.ReplyText != "操作太频繁了狸，请稍后再试" {
+		t.Errorf("third call: err = %v, want rate limit error", err)
+	}
+	// 其他用户不受影响
+	if _, err := f(testCommandMessage(2, "private", "/ping")); err != nil {
+		t.Errorf("other user: unexpected error: %v", err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, err := f(testCommandMessage(1, "private", "/ping")); err != nil {
+		t.Errorf("after window: unexpected error: %v", err)
+	}
+	if count != 4 {
+		t.Errorf("handler called %d times, want 4", count)
+	}
+}
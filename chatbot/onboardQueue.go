@@ -16,14 +16,15 @@ import (
 )
 
 func cmdStart(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig, err error) {
-	if !message.Chat.IsPrivate() {
-		return
-	}
 	var argstr = message.CommandArguments()
 	if len(argstr) > 0 {
 		args := strings.SplitN(argstr, "_", 2)
 		if args[0] == "join" {
 			return cmdJoinQueue(message, args[1])
+		} else if args[0] == "cohost" && len(args) == 2 {
+			return cmdJoinAsCoHost(message, args[1])
+		} else if args[0] == "prejoin" && len(args) == 2 {
+			return cmdPreJoinQueue(message, args[1])
 		}
 	}
 	return []tgbotapi.MessageConfig{{
@@ -40,6 +41,8 @@ func cmdStart(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig,
 队列主：
 /queue [密码] 开启新的队列
 /queue [密码] [最大客人数] 开启新的队列，同时根据队列信息，半自动邀请下一位旅客
+/queue at [时:分] [最大客人数] 预约开放队列，客人可以提前登记
+/queuetemplate 保存/使用队列模板，一步开启队列
 /myqueue 列出自己创建的队列
 /dismiss 解散自己创建的队列
 
@@ -52,9 +55,6 @@ func cmdStart(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig,
 }
 
 func cmdMyQueue(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig, err error) {
-	if !message.Chat.IsPrivate() {
-		return
-	}
 	ctx := context.Background()
 	island, _, err := storage.GetAnimalCrossingIslandByUserID(ctx, message.From.ID)
 	if err != nil {
@@ -108,24 +108,8 @@ func cmdMyQueue(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfi
 			Text: "您没有开启队列",
 		}}, nil
 	}
-	var shareBtn = tgbotapi.NewInlineKeyboardButtonSwitch("分享队列："+island.Name, "/share_"+queue.ID)
-	var dismissBtn = tgbotapi.NewInlineKeyboardButtonData("解散队列", "/dismiss_"+queue.ID)
-	var listBtn = tgbotapi.NewInlineKeyboardButtonData("查看队列", "/showqueuemember_"+queue.ID)
-	var updatePasswordBtn = tgbotapi.NewInlineKeyboardButtonData("修改密码", "/updatepassword_"+queue.ID)
-	var nextBtn = tgbotapi.NewInlineKeyboardButtonData("有请下一位", "/next_"+queue.ID)
-	var toggleQueueTypeBtnText string
-	if queue.IsAuto {
-		toggleQueueTypeBtnText = "切换为手动队列"
-	} else {
-		toggleQueueTypeBtnText = "切换为自动队列"
-	}
-	var toggleQueueTypeBtn = tgbotapi.NewInlineKeyboardButtonData(toggleQueueTypeBtnText, "/toggle_"+queue.ID)
-	var replyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(shareBtn, dismissBtn),
-		tgbotapi.NewInlineKeyboardRow(listBtn, updatePasswordBtn),
-		tgbotapi.NewInlineKeyboardRow(nextBtn),
-		tgbotapi.NewInlineKeyboardRow(toggleQueueTypeBtn),
-	)
-	var replyText = fmt.Sprintf("队列已创建成功，密码：%s\n请使用分享按钮选择要分享排队的群/朋友\n*选择群组后请等待 telegram 弹出分享提示后点击提示！*\n/updatepassword 新密码 更新密码\n/dismiss 立即解散队列\n/myqueue 列出创建的队列\n*请使用下面的按钮操作*", queue.Password)
+	var replyMarkup = queueOwnerReplyMarkup(queue)
+	var replyText = fmt.Sprintf("队列已创建成功，密码已加密保存，轮到客人时才会发给客人\n%s\n请使用分享按钮选择要分享排队的群/朋友\n*选择群组后请等待 telegram 弹出分享提示后点击提示！*\n/updatepassword 新密码 更新密码\n/dismiss 立即解散队列\n/myqueue 列出创建的队列\n*请使用下面的按钮操作*", markdownSafe(queueThroughputText(queue)))
 
 	return []tgbotapi.MessageConfig{{
 			BaseChat: tgbotapi.BaseChat{
@@ -140,52 +124,71 @@ func cmdMyQueue(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfi
 		nil
 }
 
+// updatePasswordPrompt 要求岛主回复新密码的提示，后接队列 ID
+const updatePasswordPrompt = "请输入新的密码。队列编号："
+
 func cmdUpdatePassword(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig, err error) {
 	if !message.Chat.IsPrivate() {
 		return
 	}
-	password := message.Text
-	if len(password) != 5 {
-		return []tgbotapi.MessageConfig{
-			{
-				BaseChat: tgbotapi.BaseChat{
-					ChatID: message.Chat.ID,
-				},
-				Text: "密码一定有 5 位",
-			},
-			{
-				BaseChat: tgbotapi.BaseChat{
-					ChatID:      message.Chat.ID,
-					ReplyMarkup: tgbotapi.ForceReply{ForceReply: true, Selective: true},
-				},
-				Text: "请输入新的密码",
-			},
-		}, nil
+	// 旧版本的提示不带队列 ID，只能修改自己开启的队列
+	var queueID string
+	if strings.HasPrefix(message.ReplyToMessage.Text, updatePasswordPrompt) {
+		queueID = strings.TrimSpace(strings.TrimPrefix(message.ReplyToMessage.Text, updatePasswordPrompt))
 	}
 	ctx := context.Background()
-	island, _, err := storage.GetAnimalCrossingIslandByUserID(ctx, message.From.ID)
+	client, err := firestore.NewClient(ctx, _projectID)
 	if err != nil {
-		if status.Code(err) == codes.NotFound {
+		_logger.Error().Err(err).Msg("cmdUpdatePassword newClient")
+		return nil, Error{InnerError: err,
+			ReplyText: "查询队列时出错了",
+		}
+	}
+	defer client.Close()
+	var queue *storage.OnboardQueue
+	if len(queueID) > 0 {
+		queue, err = storage.GetOnboardQueue(ctx, client, queueID)
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return nil, Error{InnerError: err,
+					ReplyText: "队列已取消",
+				}
+			}
+			_logger.Error().Err(err).Msg("cmdUpdatePassword GetOnboardQueue")
 			return nil, Error{InnerError: err,
-				ReplyText: "您还没有登记您的岛屿，请用/addisland 添加您的岛屿信息",
+				ReplyText: "查询队列时出错了",
 			}
 		}
-		_logger.Error().Err(err).Msg("cmdMyQueue GetAnimalCrossingIslandByUserID")
-		return nil, Error{InnerError: err,
-			ReplyText: "查询岛屿时出错了。",
+		if queue.Dismissed {
+			return nil, Error{ReplyText: "队列已取消"}
 		}
-	}
-	if len(island.OnBoardQueueID) == 0 {
-		return []tgbotapi.MessageConfig{{
-			BaseChat: tgbotapi.BaseChat{
-				ChatID: message.Chat.ID,
-			},
-			Text: "您没有开启队列",
-		}}, nil
-	}
-	queue, err := island.GetOnboardQueue(ctx)
-	if err != nil {
-		if status.Code(err) == codes.NotFound {
+		if !queue.IsHost(int64(message.From.ID)) {
+			return nil, Error{ReplyText: "只有岛主才能操作狸"}
+		}
+	} else {
+		island, _, err := storage.GetAnimalCrossingIslandByUserID(ctx, message.From.ID)
+		if err != nil && status.Code(err) != codes.NotFound {
+			_logger.Error().Err(err).Msg("cmdUpdatePassword GetAnimalCrossingIslandByUserID")
+			return nil, Error{InnerError: err,
+				ReplyText: "查询岛屿时出错了。",
+			}
+		}
+		if err == nil && len(island.OnBoardQueueID) > 0 {
+			queue, err = island.GetOnboardQueue(ctx)
+			if err != nil {
+				if status.Code(err) != codes.NotFound {
+					_logger.Error().Err(err).Msg("cmdUpdatePassword GetOnboardQueue")
+					return nil, Error{InnerError: err,
+						ReplyText: "查询队列时出错了",
+					}
+				}
+				queue = nil
+			} else if queue.Dismissed {
+				queue.Delete(ctx, client)
+				queue = nil
+			}
+		}
+		if queue == nil {
 			return []tgbotapi.MessageConfig{{
 				BaseChat: tgbotapi.BaseChat{
 					ChatID: message.Chat.ID,
@@ -193,54 +196,41 @@ func cmdUpdatePassword(message *tgbotapi.Message) (replyMessage []tgbotapi.Messa
 				Text: "您没有开启队列",
 			}}, nil
 		}
-		_logger.Error().Err(err).Msg("cmdMyQueue GetOnboardQueue")
-		return nil, Error{InnerError: err,
-			ReplyText: "查询队列时出错了",
-		}
 	}
-	client, err := firestore.NewClient(ctx, _projectID)
+	password, notice, err := parseDodoCode(message.Text)
 	if err != nil {
-		_logger.Error().Err(err).Msg("cmdMyQueue newClient")
+		return []tgbotapi.MessageConfig{
+			{
+				BaseChat: tgbotapi.BaseChat{
+					ChatID: message.Chat.ID,
+				},
+				Text: err.Error(),
+			},
+			{
+				BaseChat: tgbotapi.BaseChat{
+					ChatID:      message.Chat.ID,
+					ReplyMarkup: tgbotapi.ForceReply{ForceReply: true, Selective: true},
+				},
+				Text: updatePasswordPrompt + queue.ID,
+			},
+		}, nil
+	}
+	if err = queue.SetPassword(password); err != nil {
 		return nil, Error{InnerError: err,
-			ReplyText: "查询队列时出错了",
+			ReplyText: "更新队列密码时出错了",
 		}
 	}
-	defer client.Close()
-	if queue.Dismissed {
-		defer client.Close()
-		queue.Delete(ctx, client)
-		return []tgbotapi.MessageConfig{{
-			BaseChat: tgbotapi.BaseChat{
-				ChatID: message.Chat.ID,
-			},
-			Text: "您没有开启队列",
-		}}, nil
-	}
-	queue.Password = password
 	if err = queue.Update(ctx, client); err != nil {
 		return nil, Error{InnerError: err,
 			ReplyText: "更新队列密码时出错了",
 		}
 	}
-	notifyNewPassword(queue)
-	var shareBtn = tgbotapi.NewInlineKeyboardButtonSwitch("分享队列："+island.Name, "/share_"+queue.ID)
-	var dismissBtn = tgbotapi.NewInlineKeyboardButtonData("解散队列", "/dismiss_"+queue.ID)
-	var listBtn = tgbotapi.NewInlineKeyboardButtonData("查看队列", "/showqueuemember_"+queue.ID)
-	var updatePasswordBtn = tgbotapi.NewInlineKeyboardButtonData("修改密码", "/updatepassword_"+queue.ID)
-	var nextBtn = tgbotapi.NewInlineKeyboardButtonData("有请下一位", "/next_"+queue.ID)
-	var toggleQueueTypeBtnText string
-	if queue.IsAuto {
-		toggleQueueTypeBtnText = "切换为手动队列"
-	} else {
-		toggleQueueTypeBtnText = "切换为自动队列"
-	}
-	var toggleQueueTypeBtn = tgbotapi.NewInlineKeyboardButtonData(toggleQueueTypeBtnText, "/toggle_"+queue.ID)
-	var replyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(shareBtn, dismissBtn),
-		tgbotapi.NewInlineKeyboardRow(listBtn, updatePasswordBtn),
-		tgbotapi.NewInlineKeyboardRow(nextBtn),
-		tgbotapi.NewInlineKeyboardRow(toggleQueueTypeBtn),
-	)
-	var replyText = fmt.Sprintf("队列已创建成功，密码：%s\n请使用分享按钮选择要分享排队的群/朋友\n*选择群组后请等待 telegram 弹出分享提示后点击提示！*\n/dismiss 立即解散队列\n/myqueue 列出自己创建的队列\n*请使用下面的按钮操作*", queue.Password)
+	logQueueEvent(queue, storage.QueueEventPassword, int64(message.From.ID), message.From.UserName)
+	notifyNewPassword(queue, func(password string) string {
+		return "岛主更新了密码，新密码如下：\n" + password
+	}, tgbotapi.ForceReply{ForceReply: true, Selective: true})
+	var replyMarkup = queueOwnerReplyMarkup(queue)
+	var replyText = fmt.Sprintf("队列已创建成功，密码：%s\n请使用分享按钮选择要分享排队的群/朋友\n*选择群组后请等待 telegram 弹出分享提示后点击提示！*\n/dismiss 立即解散队列\n/myqueue 列出自己创建的队列\n*请使用下面的按钮操作*", markdownSafe(dodoCodeText(password, notice)))
 	tgbot.DeleteMessage(tgbotapi.NewDeleteMessage(message.Chat.ID, message.ReplyToMessage.MessageID))
 	return []tgbotapi.MessageConfig{{
 			BaseChat: tgbotapi.BaseChat{
@@ -255,14 +245,19 @@ func cmdUpdatePassword(message *tgbotapi.Message) (replyMessage []tgbotapi.Messa
 		nil
 }
 
-// notifyNewPassword to current landed user when password is update
-func notifyNewPassword(queue *storage.OnboardQueue) {
-	updatePasswordText := fmt.Sprintf("岛主更新了密码，新密码如下：\n%s", queue.Password)
+// notifyNewPassword to current landed user when password is update, passwordText builds the message from the new password
+func notifyNewPassword(queue *storage.OnboardQueue, passwordText func(password string) string, replyMarkup interface{}) {
+	password, err := queue.PlainPassword()
+	if err != nil {
+		_logger.Error().Err(err).Str("queue", queue.ID).Msg("notifyNewPassword decrypt password failed")
+		return
+	}
+	updatePasswordText := passwordText(password)
 	for _, p := range queue.Landed {
 		_, err := tgbot.Send(tgbotapi.MessageConfig{
 			BaseChat: tgbotapi.BaseChat{
 				ChatID:      int64(p.UID),
-				ReplyMarkup: tgbotapi.ForceReply{ForceReply: true, Selective: true},
+				ReplyMarkup: replyMarkup,
 			},
 			Text: updatePasswordText,
 		})
@@ -304,25 +299,65 @@ func cmdJoinQueue(message *tgbotapi.Message, queueID string) (replyMessage []tgb
 		return nil, Error{InnerError: err,
 			ReplyText: "加入队列失败"}
 	}
-	if queue.OwnerID == uid {
-		return []tgbotapi.MessageConfig{tgbotapi.NewMessage(message.Chat.ID, "自己不用排自己的队伍狸……")}, nil
+	replyText, _, _, err := joinQueue(ctx, client, queue, uid, username)
+	if err != nil {
+		return nil, err
+	}
+	if len(replyText) > 0 {
+		return []tgbotapi.MessageConfig{tgbotapi.NewMessage(message.Chat.ID, replyText)}, nil
 	}
-	if err = queue.Append(ctx, client, uid, username); err != nil {
+	return nil, nil
+}
+
+// joinQueue 让用户加入队列，并发送加入成功后的各种通知
+// replyText 不为空时表示未能加入，showAlert 表示需要弹窗提示
+func joinQueue(ctx context.Context, client *firestore.Client, queue *storage.OnboardQueue, uid int64, username string) (replyText string, showAlert, waitlisted bool, err error) {
+	if queue.IsHost(uid) {
+		return "自己不用排自己的队伍狸……", false, false, nil
+	}
+	if banned, err := storage.IsBannedByOwner(ctx, queue.OwnerID, uid); err != nil {
+		_logger.Error().Err(err).Msg("query blacklist failed")
+	} else if banned {
+		return "您无法加入这个岛主的队列", true, false, nil
+	}
+	if !canJoinQueue(queue, uid) {
+		return "只有队列分享到的群的成员才能加入这个队列狸", true, false, nil
+	}
+	if !isReliableGuest(queue, uid) {
+		return "这个队列仅限近期没有超时未到记录的客人加入狸", true, false, nil
+	}
+	waitlisted, err = queue.Join(ctx, client, uid, username)
+	if err != nil {
 		if err.Error() == "already in this queue" {
-			return []tgbotapi.MessageConfig{tgbotapi.NewMessage(message.Chat.ID, "您已经加入了这个队列")}, nil
+			return "您已经加入了这个队列", false, false, nil
 		} else if err.Error() == "already land island" {
-			return []tgbotapi.MessageConfig{tgbotapi.NewMessage(message.Chat.ID, "请离岛后再重新排队")}, nil
+			return "请离岛后再重新排队", false, false, nil
+		} else if err.Error() == "already in waitlist" {
+			return "您已经在候补名单中了", false, false, nil
 		}
 		_logger.Error().Err(err).Msg("append queue failed")
-		return nil, Error{InnerError: err,
+		return "", false, false, Error{InnerError: err,
 			ReplyText: "加入队列失败"}
 	}
+	if waitlisted {
+		// 直接发送加入候补的提示，保证登岛目的的询问排在它后面
+		tgbot.Send(tgbotapi.MessageConfig{
+			BaseChat: tgbotapi.BaseChat{
+				ChatID:      uid,
+				ReplyMarkup: queueWaitlistReplyMarkup(queue),
+			},
+			Text: waitlistJoinedText(queue, uid),
+		})
+		sendGuestVisitPrompt(queue, uid)
+		return "", false, true, nil
+	}
+	logQueueEvent(queue, storage.QueueEventJoin, uid, username)
 	t := queue.Len()
 	l, err := queue.GetPosition(uid)
 	if err != nil {
 		t++
 	}
-	if queue.IsAuto && queue.LandedLen() < queue.MaxGuestCount {
+	if queue.IsAuto && !queue.Paused && !queue.IsLotteryPending() && queue.LandedLen() < queue.MaxGuestCount {
 		sendNotify(ctx, client, queue)
 	} else {
 		var queueType string
@@ -331,15 +366,13 @@ func cmdJoinQueue(message *tgbotapi.Message, queueID string) (replyMessage []tgb
 		} else {
 			queueType = "岛主手动控制队列"
 		}
-		var myPositionBtn = tgbotapi.NewInlineKeyboardButtonData("我的位置？", fmt.Sprintf("/position_%s|%d", queue.ID, time.Now().Unix()))
-		var leaveBtn = tgbotapi.NewInlineKeyboardButtonData("离开队列："+queue.Name, "/leave_"+queue.ID)
-		var replyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(myPositionBtn, leaveBtn))
+		var replyMarkup = queueGuestReplyMarkup(queue, time.Now().Unix())
 		tgbot.Send(tgbotapi.MessageConfig{
 			BaseChat: tgbotapi.BaseChat{
 				ChatID:      uid,
 				ReplyMarkup: replyMarkup,
 			},
-			Text: fmt.Sprintf("已加入前往 %s 的队列中排队，本队列为 %s ，当前位置：%d/%d。\n当前岛上有 %d 个客人", queue.Name, queueType, l, t, queue.LandedLen()),
+			Text: fmt.Sprintf("已加入前往 %s 的队列中排队，本队列为 %s ，当前位置：%d/%d。\n当前岛上有 %d 个客人%s%s%s", queue.Name, queueType, l, t, queue.LandedLen(), estimatedWaitText(queue, l), lotteryTokenText(queue, uid), queueIntroText(queue)),
 		})
 		sentMsg, err := tgbot.Send(tgbotapi.MessageConfig{
 			BaseChat: tgbotapi.BaseChat{
@@ -356,13 +389,11 @@ func cmdJoinQueue(message *tgbotapi.Message, queueID string) (replyMessage []tgb
 			}()
 		}
 	}
-	return nil, nil
+	sendGuestVisitPrompt(queue, uid)
+	return "", false, false, nil
 }
 
 func cmdJoinedQueue(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig, err error) {
-	if !message.Chat.IsPrivate() {
-		return
-	}
 	ctx := context.Background()
 	uid := int64(message.From.ID)
 	queues, err := storage.GetJoinedQueue(ctx, uid)
@@ -379,13 +410,18 @@ func cmdJoinedQueue(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageC
 		}
 	}
 	var rows [][]tgbotapi.InlineKeyboardButton
-	for _, q := range queues {
+	var replyText = "请选择要操作的队列"
+	for i := range queues {
+		q := &queues[i]
 		position, err := q.GetPosition(uid)
 		if err != nil {
 			continue
 		}
 		var btn = tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("队列：%s，位置 %d/%d", q.Name, position, q.Len()), fmt.Sprintf("/showqueueinfo_%s", q.ID))
 		rows = append(rows, tgbotapi.NewInlineKeyboardRow(btn))
+		if wait := estimatedWaitText(q, position); len(wait) > 0 {
+			replyText += fmt.Sprintf("\n%s：%s", q.Name, wait[1:])
+		}
 	}
 	var replyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
 
@@ -394,7 +430,7 @@ func cmdJoinedQueue(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageC
 			ChatID:      uid,
 			ReplyMarkup: replyMarkup,
 		},
-		Text: "请选择要操作的队列",
+		Text: replyText,
 	}}, nil
 }
 
@@ -430,6 +466,9 @@ func cmdOpenIslandQueue(message *tgbotapi.Message) (replyMessage []tgbotapi.Mess
 	if residentUID > 0 {
 		uid = residentUID
 	}
+	if args := strings.Fields(message.CommandArguments()); len(args) > 0 && args[0] == "at" {
+		return cmdScheduleIslandQueue(message, island, uid, args[1:])
+	}
 	if len(island.OnBoardQueueID) != 0 {
 		queue, _ := island.GetOnboardQueue(ctx)
 		if queue != nil {
@@ -447,14 +486,12 @@ func cmdOpenIslandQueue(message *tgbotapi.Message) (replyMessage []tgbotapi.Mess
 	args := strings.Split(argstr, " ")
 	if len(args) == 0 {
 		return nil, Error{InnerError: err,
-			ReplyText: "/queue 指令至少需要一个参数：开岛密码。请使用下面格式：\n/queue [密码] 开启新的队列\n/queue [密码] [最大客人数] 开启新的队列，同时根据队列信息，半自动邀请下一位旅客",
+			ReplyText: "/queue 指令至少需要一个参数：开岛密码。请使用下面格式：\n/queue [密码] 开启新的队列\n/queue [密码] [最大客人数] 开启新的队列，同时根据队列信息，半自动邀请下一位旅客\n" + queueScheduleUsage,
 		}
 	}
-	password := args[0]
-	if len(password) != 5 {
-		return nil, Error{InnerError: err,
-			ReplyText: "请输入 Dodo Airlines 工作人员 莫里（Orville）提供的 5 位密码",
-		}
+	password, notice, err := parseDodoCode(args[0])
+	if err != nil {
+		return nil, err
 	}
 	var maxGuestCount = 0
 	if len(args) == 2 {
@@ -486,23 +523,8 @@ func cmdOpenIslandQueue(message *tgbotapi.Message) (replyMessage []tgbotapi.Mess
 				Text: fmt.Sprintf("创建队列 %s 时出错，error：%v", queue.Name, err)},
 		}, nil
 	}
-	var shareBtn = tgbotapi.NewInlineKeyboardButtonSwitch("分享队列："+island.Name, "/share_"+queue.ID)
-	var dismissBtn = tgbotapi.NewInlineKeyboardButtonData("解散队列", "/dismiss_"+queue.ID)
-	var listBtn = tgbotapi.NewInlineKeyboardButtonData("查看队列", "/showqueuemember_"+queue.ID)
-	var updatePasswordBtn = tgbotapi.NewInlineKeyboardButtonData("修改密码", "/updatepassword_"+queue.ID)
-	var nextBtn = tgbotapi.NewInlineKeyboardButtonData("有请下一位", "/next_"+queue.ID)
-	var toggleQueueTypeBtnText string
-	if queue.IsAuto {
-		toggleQueueTypeBtnText = "切换为手动队列"
-	} else {
-		toggleQueueTypeBtnText = "切换为自动队列"
-	}
-	var toggleQueueTypeBtn = tgbotapi.NewInlineKeyboardButtonData(toggleQueueTypeBtnText, "/toggle_"+queue.ID)
-	var replyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(shareBtn, dismissBtn),
-		tgbotapi.NewInlineKeyboardRow(listBtn, updatePasswordBtn),
-		tgbotapi.NewInlineKeyboardRow(nextBtn),
-		tgbotapi.NewInlineKeyboardRow(toggleQueueTypeBtn))
-	var replyText = fmt.Sprintf("队列已创建成功，密码：%s\n请使用分享按钮选择要分享排队的群/朋友\n*选择群组后请等待 telegram 弹出分享提示后点击提示！*\n/dismiss 立即解散队列\n/myqueue 列出自己创建的队列\n/comment 留下您的建议或意见\n/donate 您愿意的话可以捐助本项目\n*请使用下面的按钮操作*", queue.Password)
+	var replyMarkup = queueOwnerReplyMarkup(queue)
+	var replyText = fmt.Sprintf("队列已创建成功，密码：%s\n请使用分享按钮选择要分享排队的群/朋友\n*选择群组后请等待 telegram 弹出分享提示后点击提示！*\n/dismiss 立即解散队列\n/myqueue 列出自己创建的队列\n/comment 留下您的建议或意见\n/donate 您愿意的话可以捐助本项目\n*请使用下面的按钮操作*", markdownSafe(dodoCodeText(password, notice)))
 
 	return []tgbotapi.MessageConfig{{
 			BaseChat: tgbotapi.BaseChat{
@@ -551,7 +573,7 @@ func cmdDismissIslandQueue(message *tgbotapi.Message) (replyMessage []tgbotapi.M
 			ReplyText: "当前没有创建登岛队列狸。如有需要请使用 /queue [密码] [最大同时登岛客人数] 创建队列",
 		}
 	}
-	queue, err := island.ClearOldOnboardQueue(ctx)
+	queue, err := dismissIslandQueue(ctx, island)
 	if err != nil {
 		return []tgbotapi.MessageConfig{{
 			BaseChat: tgbotapi.BaseChat{
@@ -567,7 +589,6 @@ func cmdDismissIslandQueue(message *tgbotapi.Message) (replyMessage []tgbotapi.M
 				Text: fmt.Sprintf("清理队列 %s 时出错，error：%v", queue.Name, err)},
 		}, nil
 	}
-	replyMessage = append(replyMessage, notifyQueueDissmised(queue)...)
 	replyMessage = append(replyMessage, tgbotapi.MessageConfig{
 		BaseChat: tgbotapi.BaseChat{
 			ChatID:              message.Chat.ID,
@@ -579,8 +600,43 @@ func cmdDismissIslandQueue(message *tgbotapi.Message) (replyMessage []tgbotapi.M
 	return
 }
 
+// dismissIslandQueue 解散岛屿当前的队列，并完成解散后的通知和统计
+func dismissIslandQueue(ctx context.Context, island *storage.Island) (queue *storage.OnboardQueue, err error) {
+	queue, err = island.ClearOldOnboardQueue(ctx)
+	if err != nil {
+		return
+	}
+	finishDismissedQueue(queue)
+	return
+}
+
+// dismissQueue 删除队列，并完成解散后的通知和统计
+func dismissQueue(ctx context.Context, client *firestore.Client, queue *storage.OnboardQueue) (err error) {
+	queue.Dismissed = true
+	if err = queue.Delete(ctx, client); err != nil {
+		return
+	}
+	finishDismissedQueue(queue)
+	return
+}
+
+// finishDismissedQueue 队列解散后：通知排队和候补的客人，统计本次队列并发给岛主
+func finishDismissedQueue(queue *storage.OnboardQueue) {
+	if queue == nil || len(queue.ID) == 0 {
+		return
+	}
+	for _, m := range notifyQueueDissmised(queue) {
+		if _, err := tgbot.Send(m); err != nil {
+			_logger.Info().Err(err).Int64("uid", m.ChatID).Msg("notify queue dismissed failed")
+		}
+	}
+	sendQueueSummary(queue)
+}
+
 func notifyQueueDissmised(queue *storage.OnboardQueue) (replyMessage []tgbotapi.MessageConfig) {
-	for _, p := range queue.Queue {
+	closeQueueCards(queue)
+	// 候补名单中的客人也要通知，限定容量避免 append 改动 queue.Queue
+	for _, p := range append(queue.Queue[:queue.Len():queue.Len()], queue.Waitlist...) {
 		replyMessage = append(replyMessage, tgbotapi.MessageConfig{
 			BaseChat: tgbotapi.BaseChat{
 				ChatID:              p.UID,
@@ -591,3 +647,45 @@ func notifyQueueDissmised(queue *storage.OnboardQueue) (replyMessage []tgbotapi.
 	}
 	return
 }
+
+// queueGuestReplyMarkup 排队中客人的操作按钮，joinTime 为加入队列的时间
+func queueGuestReplyMarkup(queue *storage.OnboardQueue, joinTime int64) tgbotapi.InlineKeyboardMarkup {
+	var myPositionBtn = tgbotapi.NewInlineKeyboardButtonData("我的位置？", fmt.Sprintf("/position_%s|%d", queue.ID, joinTime))
+	var leaveBtn = tgbotapi.NewInlineKeyboardButtonData("离开队列："+queue.Name, "/leave_"+queue.ID)
+	if queue.Privacy() == storage.QueuePrivacyOwner {
+		return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(myPositionBtn, leaveBtn))
+	}
+	var listBtn = tgbotapi.NewInlineKeyboardButtonData("查看队列", "/showqueuemember_"+queue.ID)
+	return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(myPositionBtn, leaveBtn), tgbotapi.NewInlineKeyboardRow(listBtn))
+}
+
+// queueOwnerReplyMarkup 队列主操作面板
+func queueOwnerReplyMarkup(queue *storage.OnboardQueue) tgbotapi.InlineKeyboardMarkup {
+	var shareBtn = tgbotapi.NewInlineKeyboardButtonSwitch("分享队列："+queue.Name, "/share_"+queue.ID)
+	var dismissBtn = tgbotapi.NewInlineKeyboardButtonData("解散队列", "/dismiss_"+queue.ID)
+	var listBtn = tgbotapi.NewInlineKeyboardButtonData("查看队列", "/showqueuemember_"+queue.ID)
+	var updatePasswordBtn = tgbotapi.NewInlineKeyboardButtonData("修改密码", "/updatepassword_"+queue.ID)
+	var nextBtn = tgbotapi.NewInlineKeyboardButtonData("有请下一位", "/next_"+queue.ID)
+	var pickBtn = tgbotapi.NewInlineKeyboardButtonData("选择下一位", "/pick_"+queue.ID)
+	var toggleQueueTypeBtnText string
+	if queue.IsAuto {
+		toggleQueueTypeBtnText = "切换为手动队列"
+	} else {
+		toggleQueueTypeBtnText = "切换为自动队列"
+	}
+	var toggleQueueTypeBtn = tgbotapi.NewInlineKeyboardButtonData(toggleQueueTypeBtnText, "/toggle_"+queue.ID)
+	var coHostBtn = tgbotapi.NewInlineKeyboardButtonData("协作岛主", "/cohost_"+queue.ID)
+	var pauseBtn = tgbotapi.NewInlineKeyboardButtonData("暂停邀请", "/pause_"+queue.ID)
+	if queue.Paused {
+		pauseBtn = tgbotapi.NewInlineKeyboardButtonData("恢复邀请", "/resume_"+queue.ID)
+	}
+	var crashBtn = tgbotapi.NewInlineKeyboardButtonData("炸岛了", "/crash_"+queue.ID)
+	var broadcastBtn = tgbotapi.NewInlineKeyboardButtonData("发公告", "/broadcast_"+queue.ID)
+	return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(shareBtn, dismissBtn),
+		tgbotapi.NewInlineKeyboardRow(listBtn, updatePasswordBtn),
+		tgbotapi.NewInlineKeyboardRow(nextBtn, pickBtn),
+		tgbotapi.NewInlineKeyboardRow(toggleQueueTypeBtn, coHostBtn),
+		tgbotapi.NewInlineKeyboardRow(pauseBtn, crashBtn),
+		tgbotapi.NewInlineKeyboardRow(broadcastBtn),
+	)
+}
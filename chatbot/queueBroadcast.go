@@ -0,0 +1,165 @@
+package chatbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/doylecnn/new-nsfc-bot/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	// queueBroadcastPrompt 要求岛主回复公告内容的提示，后接队列 ID
+	queueBroadcastPrompt = "请回复要发给排队和在岛客人的公告狸。队列编号："
+	// queueBroadcastInterval 两条公告之间的间隔，避免触发 Telegram 的发送频率限制
+	queueBroadcastInterval = 100 * time.Millisecond
+)
+
+// queueBroadcastUIDs 公告的收件人：排队和在岛的客人，不含发公告的岛主
+func queueBroadcastUIDs(queue *storage.OnboardQueue, senderID int64) (uids []int64) {
+	var sent = map[int64]bool{senderID: true}
+	for _, uid := range queue.UIDs {
+		if !sent[uid] {
+			sent[uid] = true
+			uids = append(uids, uid)
+		}
+	}
+	for _, g := range queue.Landed {
+		if !sent[g.UID] {
+			sent[g.UID] = true
+			uids = append(uids, g.UID)
+		}
+	}
+	return
+}
+
+func callbackQueryQueueBroadcast(query *tgbotapi.CallbackQuery) (callbackConfig tgbotapi.CallbackConfig, err error) {
+	queueID := query.Data[11:]
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, _projectID)
+	if err != nil {
+		_logger.Error().Err(err).Msg("create firestore client failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	defer client.Close()
+	queue, err := storage.GetOnboardQueue(ctx, client, queueID)
+	if err != nil {
+		var text = "failed"
+		if status.Code(err) == codes.NotFound {
+			text = "队列已取消"
+		} else {
+			_logger.Error().Err(err).Msg("query queue failed")
+		}
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            text,
+			ShowAlert:       false,
+		}, nil
+	}
+	if !queue.IsHost(int64(query.From.ID)) {
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "只有岛主才能操作狸",
+			ShowAlert:       false,
+		}, nil
+	}
+	_, err = tgbot.Send(tgbotapi.MessageConfig{
+		BaseChat: tgbotapi.BaseChat{
+			ChatID:      int64(query.From.ID),
+			ReplyMarkup: tgbotapi.ForceReply{ForceReply: true, Selective: true},
+		},
+		Text: queueBroadcastPrompt + queue.ID,
+	})
+	if err != nil {
+		_logger.Error().Err(err).Int("uid", query.From.ID).Msg("send queue broadcast prompt failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	err = errors.New("no_alert")
+	return
+}
+
+// cmdQueueBroadcast 岛主回复公告内容后，逐个发给排队和在岛的客人，发完后把送达情况告诉岛主
+func cmdQueueBroadcast(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig, err error) {
+	if !message.Chat.IsPrivate() {
+		return
+	}
+	promptArgs := strings.Fields(strings.TrimPrefix(message.ReplyToMessage.Text, queueBroadcastPrompt))
+	if len(promptArgs) == 0 {
+		return nil, Error{ReplyText: "无法识别队列编号狸"}
+	}
+	queueID := promptArgs[0]
+	text := strings.TrimSpace(message.Text)
+	if len(text) == 0 {
+		return nil, Error{ReplyText: "公告只能是文字狸"}
+	}
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, _projectID)
+	if err != nil {
+		_logger.Error().Err(err).Msg("cmdQueueBroadcast newClient")
+		return nil, Error{InnerError: err,
+			ReplyText: "查询队列时出错了",
+		}
+	}
+	defer client.Close()
+	queue, err := storage.GetOnboardQueue(ctx, client, queueID)
+	if err != nil || queue.Dismissed {
+		return nil, Error{InnerError: err,
+			ReplyText: "队列已取消",
+		}
+	}
+	senderID := int64(message.From.ID)
+	if !queue.IsHost(senderID) {
+		return nil, Error{ReplyText: "只有岛主才能发公告狸"}
+	}
+	uids := queueBroadcastUIDs(queue, senderID)
+	if len(uids) == 0 {
+		return nil, Error{ReplyText: "队列中和岛上都没有客人，公告没有发送狸"}
+	}
+	go sendQueueBroadcast(queue, senderID, uids, fmt.Sprintf("来自 %s 岛主的公告：\n%s", queue.Name, text))
+	return []tgbotapi.MessageConfig{{
+			BaseChat: tgbotapi.BaseChat{
+				ChatID:           message.Chat.ID,
+				ReplyToMessageID: message.MessageID,
+			},
+			Text: fmt.Sprintf("正在把公告发给 %d 位客人，发送完成后会告诉您结果狸", len(uids)),
+		}},
+		nil
+}
+
+// sendQueueBroadcast 按 queueBroadcastInterval 的间隔逐个发送公告，最后发送送达报告
+func sendQueueBroadcast(queue *storage.OnboardQueue, senderID int64, uids []int64, text string) {
+	var delivered, failed int
+	for i, uid := range uids {
+		if i > 0 {
+			time.Sleep(queueBroadcastInterval)
+		}
+		if _, err := tgbot.Send(tgbotapi.NewMessage(uid, text)); err != nil {
+			_logger.Info().Err(err).Int64("uid", uid).Str("queue", queue.ID).Msg("send queue broadcast failed")
+			failed++
+			continue
+		}
+		delivered++
+	}
+	var report = fmt.Sprintf("前往 %s 的公告已发送完毕：\n送达：%d 位\n失败：%d 位", queue.Name, delivered, failed)
+	if failed > 0 {
+		report += "\n发送失败的客人可能已停用或屏蔽了机器人"
+	}
+	if _, err := tgbot.Send(tgbotapi.NewMessage(senderID, report)); err != nil {
+		_logger.Info().Err(err).Int64("uid", senderID).Msg("send queue broadcast report failed")
+	}
+}
@@ -0,0 +1,159 @@
+package chatbot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/doylecnn/new-nsfc-bot/storage"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// queueCardText 分享到群里的队列卡片，显示队列的实时状态
+func queueCardText(queue *storage.OnboardQueue, island *storage.Island) (text string, joinText string) {
+	var info = queue.IslandInfo
+	var airportState = "开放中"
+	if island != nil {
+		info = island.Info
+		if !island.AirportIsOpen {
+			airportState = "已关闭"
+		}
+	}
+	if queue.Paused {
+		airportState += "（暂停邀请中）"
+	}
+	if queue.IsLotteryPending() {
+		airportState += "（抽签报名中）"
+	}
+	joinText = "加入队列"
+	if queue.IsFull() {
+		// 满员时显示候补人数，点击后进入候补名单
+		joinText = queueFullText(queue)
+	}
+	text = fmt.Sprintf("邀请您加入前往 %s 的队列\n本次信息：%s\n机场：%s\n排队：%d 人，岛上：%d 人", queue.Name, info, airportState, queue.Len(), queue.LandedLen())
+	if queue.WaitlistLen() > 0 {
+		text += fmt.Sprintf("，候补：%d 人", queue.WaitlistLen())
+	}
+	text += fmt.Sprintf("\n点击“%s”按钮后，请再点击“start”按钮\n加入链接：%s", joinText, queueJoinURLPrefix+queue.ID)
+	return
+}
+
+// queueCardReplyMarkup 队列卡片上的加入按钮
+func queueCardReplyMarkup(queue *storage.OnboardQueue, joinText string) tgbotapi.InlineKeyboardMarkup {
+	var joinBtn = tgbotapi.NewInlineKeyboardButtonURL(joinText, queueJoinURLPrefix+queue.ID)
+	return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(joinBtn))
+}
+
+// chosenInlineResultShareQueue 岛主选择发送队列卡片后，记录卡片以便之后更新
+func chosenInlineResultShareQueue(result *tgbotapi.ChosenInlineResult) (err error) {
+	if len(result.InlineMessageID) == 0 {
+		return
+	}
+	queueID := result.Query[7:]
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, _projectID)
+	if err != nil {
+		return
+	}
+	defer client.Close()
+	queue, err := storage.GetOnboardQueue(ctx, client, queueID)
+	if err != nil {
+		return
+	}
+	if queue.Dismissed || !queue.IsHost(int64(result.From.ID)) {
+		return
+	}
+	if err = queue.AddSharedCard(ctx, client, result.InlineMessageID); err != nil {
+		return
+	}
+	// inline 结果可能被 Telegram 缓存，发送后立即刷新一次
+	refreshQueueCards(queue)
+	return
+}
+
+// queueCardRefreshDelay 队列变化后等待这么久再刷新卡片，期间的多次变化只刷新一次
+const queueCardRefreshDelay = 5 * time.Second
+
+var (
+	queueCardRefreshMu      sync.Mutex
+	queueCardRefreshPending = make(map[string]bool)
+)
+
+// scheduleQueueCardRefresh 稍后刷新队列卡片，一次操作产生的多个事件合并为一次刷新，避免触发 Telegram 的频率限制
+func scheduleQueueCardRefresh(queue *storage.OnboardQueue) {
+	if len(queue.SharedCardIDs) == 0 {
+		return
+	}
+	queueCardRefreshMu.Lock()
+	defer queueCardRefreshMu.Unlock()
+	if queueCardRefreshPending[queue.ID] {
+		return
+	}
+	queueCardRefreshPending[queue.ID] = true
+	queueID := queue.ID
+	time.AfterFunc(queueCardRefreshDelay, func() {
+		queueCardRefreshMu.Lock()
+		delete(queueCardRefreshPending, queueID)
+		queueCardRefreshMu.Unlock()
+		ctx := context.Background()
+		client, err := firestore.NewClient(ctx, _projectID)
+		if err != nil {
+			_logger.Error().Err(err).Msg("scheduleQueueCardRefresh create firestore client failed")
+			return
+		}
+		defer client.Close()
+		// 按刷新时最新的队列生成卡片
+		latest, err := storage.GetOnboardQueue(ctx, client, queueID)
+		if err != nil {
+			_logger.Info().Err(err).Str("queue", queueID).Msg("scheduleQueueCardRefresh get queue failed")
+			return
+		}
+		if latest.Dismissed {
+			return
+		}
+		refreshQueueCards(latest)
+	})
+}
+
+// refreshQueueCards 队列有变化时，更新分享出去的所有队列卡片
+func refreshQueueCards(queue *storage.OnboardQueue) {
+	if len(queue.SharedCardIDs) == 0 {
+		return
+	}
+	island, _, err := storage.GetAnimalCrossingIslandByUserID(context.Background(), int(queue.OwnerID))
+	if err != nil {
+		_logger.Warn().Err(err).Int64("uid", queue.OwnerID).Msg("refreshQueueCards get island failed")
+		island = nil
+	}
+	text, joinText := queueCardText(queue, island)
+	var replyMarkup = queueCardReplyMarkup(queue, joinText)
+	editQueueCards(queue, text, &replyMarkup)
+}
+
+// closeQueueCards 队列解散后，把分享出去的队列卡片标记为已关闭
+func closeQueueCards(queue *storage.OnboardQueue) {
+	editQueueCards(queue, fmt.Sprintf("前往 %s 的队列已关闭狸", queue.Name), nil)
+}
+
+func editQueueCards(queue *storage.OnboardQueue, text string, replyMarkup *tgbotapi.InlineKeyboardMarkup) {
+	for _, inlineMessageID := range queue.SharedCardIDs {
+		_, err := tgbot.Send(tgbotapi.EditMessageTextConfig{
+			BaseEdit: tgbotapi.BaseEdit{
+				InlineMessageID: inlineMessageID,
+				ReplyMarkup:     replyMarkup,
+			},
+			Text: text,
+		})
+		if err != nil {
+			// 内容没有变化时 Telegram 也会返回错误
+			if strings.Contains(err.Error(), "message is not modified") {
+				continue
+			}
+			_logger.Info().Err(err).Str("queue", queue.ID).Str("card", inlineMessageID).Msg("edit queue card failed")
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package chatbot
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"github.com/doylecnn/new-nsfc-bot/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// coHostURLPrefix 邀请协作岛主的链接前缀，后接 队列ID_token
+const coHostURLPrefix = "https://t.me/NS_FC_bot?start=cohost_"
+
+// notifyHosts 给岛主和所有协作岛主发送消息，并附上队列操作面板
+func notifyHosts(queue *storage.OnboardQueue, text string) {
+	var replyMarkup = queueOwnerReplyMarkup(queue)
+	for _, uid := range queue.HostIDs() {
+		_, err := tgbot.Send(tgbotapi.MessageConfig{
+			BaseChat: tgbotapi.BaseChat{
+				ChatID:      uid,
+				ReplyMarkup: replyMarkup,
+			},
+			Text: text,
+		})
+		if err != nil {
+			_logger.Error().Err(err).Int64("uid", uid).Str("queue", queue.ID).Msg("notify host failed")
+		}
+	}
+}
+
+// newCoHostInviteToken 生成邀请协作岛主的 token，持有 token 即可控制队列，必须不可预测
+func newCoHostInviteToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// callbackQueryCoHosts 岛主查看协作岛主，并获得邀请链接
+func callbackQueryCoHosts(query *tgbotapi.CallbackQuery) (callbackConfig tgbotapi.CallbackConfig, err error) {
+	queueID := query.Data[8:]
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, _projectID)
+	if err != nil {
+		_logger.Error().Err(err).Msg("create firestore client failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	defer client.Close()
+	queue, failed := getQueueForHost(ctx, client, query, queueID)
+	if failed != nil {
+		return *failed, nil
+	}
+	if queue.OwnerID != int64(query.From.ID) {
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "只有岛主才能管理协作岛主狸",
+			ShowAlert:       false,
+		}, nil
+	}
+	if len(queue.CoHostInviteToken) == 0 {
+		token, err := newCoHostInviteToken()
+		if err != nil {
+			_logger.Error().Err(err).Msg("generate CoHostInviteToken failed")
+			return tgbotapi.CallbackConfig{
+				CallbackQueryID: query.ID,
+				Text:            "failed",
+				ShowAlert:       false,
+			}, nil
+		}
+		if err = queue.UpdateSetting(ctx, client, "CoHostInviteToken", token); err != nil {
+			_logger.Error().Err(err).Msg("update CoHostInviteToken failed")
+			return tgbotapi.CallbackConfig{
+				CallbackQueryID: query.ID,
+				Text:            "failed",
+				ShowAlert:       false,
+			}, nil
+		}
+		queue.CoHostInviteToken = token
+	}
+	var replyText = fmt.Sprintf("把下面的链接发给一起开岛的朋友，对方点击后即可成为协作岛主，拥有和您相同的队列操作面板：\n%s%s_%s", coHostURLPrefix, queue.ID, queue.CoHostInviteToken)
+	var rows [][]tgbotapi.InlineKeyboardButton
+	if len(queue.CoHostIDs) > 0 {
+		replyText += "\n\n当前协作岛主："
+		for _, uid := range queue.CoHostIDs {
+			name := queue.CoHostNames[strconv.FormatInt(uid, 10)]
+			replyText += "\n" + guestDisplayName(name, uid)
+			if len(name) == 0 {
+				name = strconv.FormatInt(uid, 10)
+			}
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("移除协作岛主 "+name, fmt.Sprintf("/rmcohost_%s|%d", queue.ID, uid))))
+		}
+	}
+	var msg = tgbotapi.NewMessage(int64(query.From.ID), replyText)
+	if len(rows) > 0 {
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	}
+	if _, err = tgbot.Send(msg); err != nil {
+		_logger.Error().Err(err).Msg("send co-host invite failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	err = errors.New("no_alert")
+	return
+}
+
+// cmdJoinAsCoHost 通过邀请链接成为协作岛主，params 为 队列ID_token
+func cmdJoinAsCoHost(message *tgbotapi.Message, params string) (replyMessage []tgbotapi.MessageConfig, err error) {
+	sep := strings.SplitN(params, "_", 2)
+	if len(sep) != 2 {
+		return []tgbotapi.MessageConfig{tgbotapi.NewMessage(message.Chat.ID, "邀请链接无效狸")}, nil
+	}
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, _projectID)
+	if err != nil {
+		_logger.Error().Err(err).Msg("create firestore client failed")
+		return nil, Error{InnerError: err,
+			ReplyText: "成为协作岛主失败"}
+	}
+	defer client.Close()
+	queue, err := storage.GetOnboardQueue(ctx, client, sep[0])
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return []tgbotapi.MessageConfig{tgbotapi.NewMessage(message.Chat.ID, "队列已取消")}, nil
+		}
+		_logger.Error().Err(err).Msg("query queue failed")
+		return nil, Error{InnerError: err,
+			ReplyText: "成为协作岛主失败"}
+	}
+	if queue.Dismissed || len(queue.CoHostInviteToken) == 0 || subtle.ConstantTimeCompare([]byte(queue.CoHostInviteToken), []byte(sep[1])) != 1 {
+		return []tgbotapi.MessageConfig{tgbotapi.NewMessage(message.Chat.ID, "邀请链接无效狸")}, nil
+	}
+	uid := int64(message.From.ID)
+	name := message.From.UserName
+	if len(name) == 0 {
+		name = message.From.FirstName
+	}
+	if _, inQueue := queue.GetGuestName(uid); inQueue {
+		return []tgbotapi.MessageConfig{tgbotapi.NewMessage(message.Chat.ID, "请先离开这个队列，再成为协作岛主")}, nil
+	}
+	if err = queue.AddCoHost(ctx, client, uid, name); err != nil {
+		if err.Error() == "already host" {
+			return []tgbotapi.MessageConfig{tgbotapi.NewMessage(message.Chat.ID, "您已经是这个队列的岛主了")}, nil
+		}
+		_logger.Error().Err(err).Msg("add co-host failed")
+		return nil, Error{InnerError: err,
+			ReplyText: "成为协作岛主失败"}
+	}
+	tgbot.Send(tgbotapi.NewMessage(queue.OwnerID, fmt.Sprintf("@%s 已成为前往 %s 的队列的协作岛主", name, queue.Name)))
+	return []tgbotapi.MessageConfig{{
+			BaseChat: tgbotapi.BaseChat{
+				ChatID:      message.Chat.ID,
+				ReplyMarkup: queueOwnerReplyMarkup(queue),
+			},
+			Text: fmt.Sprintf("您已成为前往 %s 的队列的协作岛主，可以使用下面的按钮操作队列，客人的动态也会通知给您", queue.Name),
+		}},
+		nil
+}
+
+func callbackQueryRemoveCoHost(query *tgbotapi.CallbackQuery) (callbackConfig tgbotapi.CallbackConfig, err error) {
+	queueID, coHostUID, err := parseQueueGuestParams(query.Data[10:])
+	if err != nil {
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "wrong parameters",
+			ShowAlert:       false,
+		}, nil
+	}
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, _projectID)
+	if err != nil {
+		_logger.Error().Err(err).Msg("create firestore client failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	defer client.Close()
+	queue, failed := getQueueForHost(ctx, client, query, queueID)
+	if failed != nil {
+		return *failed, nil
+	}
+	if queue.OwnerID != int64(query.From.ID) {
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "只有岛主才能管理协作岛主狸",
+			ShowAlert:       false,
+		}, nil
+	}
+	if err = queue.RemoveCoHost(ctx, client, coHostUID); err != nil {
+		_logger.Error().Err(err).Msg("remove co-host failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	tgbot.Send(tgbotapi.NewMessage(coHostUID, fmt.Sprintf("您已不再是前往 %s 的队列的协作岛主", queue.Name)))
+	tgbot.DeleteMessage(tgbotapi.NewDeleteMessage(int64(query.From.ID), query.Message.MessageID))
+	return tgbotapi.CallbackConfig{
+		CallbackQueryID: query.ID,
+		Text:            "已移除协作岛主",
+		ShowAlert:       false,
+	}, nil
+}
@@ -0,0 +1,247 @@
+package chatbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/doylecnn/new-nsfc-bot/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	// islandCrashPasswordPrompt 炸岛后要求岛主回复新密码的提示，后接队列 ID
+	islandCrashPasswordPrompt = "炸岛了狸！请回复新的开岛密码，将发给所有在岛的客人。队列编号："
+	// islandCrashRejoinTimeout 炸岛后在岛客人回复的时限，超时未回复的客人回到队首
+	islandCrashRejoinTimeout = 5 * time.Minute
+)
+
+// islandCrashReplyMarkup 炸岛后发给在岛客人的按钮
+func islandCrashReplyMarkup(queue *storage.OnboardQueue) tgbotapi.InlineKeyboardMarkup {
+	var rejoinBtn = tgbotapi.NewInlineKeyboardButtonData("马上重新登岛", "/rejoin_"+queue.ID)
+	var doneBtn = tgbotapi.NewInlineKeyboardButtonData("我玩好了", "/finish_"+queue.ID)
+	return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(rejoinBtn, doneBtn))
+}
+
+func callbackQueryIslandCrashed(query *tgbotapi.CallbackQuery) (callbackConfig tgbotapi.CallbackConfig, err error) {
+	queueID := query.Data[7:]
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, _projectID)
+	if err != nil {
+		_logger.Error().Err(err).Msg("create firestore client failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	defer client.Close()
+	queue, err := storage.GetOnboardQueue(ctx, client, queueID)
+	if err != nil {
+		var text = "failed"
+		if status.Code(err) == codes.NotFound {
+			text = "队列已取消"
+		} else {
+			_logger.Error().Err(err).Msg("query queue failed")
+		}
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            text,
+			ShowAlert:       false,
+		}, nil
+	}
+	if !queue.IsHost(int64(query.From.ID)) {
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "只有岛主才能操作狸",
+			ShowAlert:       false,
+		}, nil
+	}
+	_, err = tgbot.Send(tgbotapi.MessageConfig{
+		BaseChat: tgbotapi.BaseChat{
+			ChatID:      int64(query.From.ID),
+			ReplyMarkup: tgbotapi.ForceReply{ForceReply: true, Selective: true},
+		},
+		Text: islandCrashPasswordPrompt + queue.ID,
+	})
+	if err != nil {
+		_logger.Error().Err(err).Int("uid", query.From.ID).Msg("send island crash password prompt failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	err = errors.New("no_alert")
+	return
+}
+
+// cmdIslandCrashed 岛主回复新密码后，发给所有在岛客人，由客人选择马上重新登岛或者结束
+func cmdIslandCrashed(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig, err error) {
+	if !message.Chat.IsPrivate() {
+		return
+	}
+	promptArgs := strings.Fields(strings.TrimPrefix(message.ReplyToMessage.Text, islandCrashPasswordPrompt))
+	if len(promptArgs) == 0 {
+		return nil, Error{ReplyText: "无法识别队列编号狸"}
+	}
+	queueID := promptArgs[0]
+	password, notice, err := parseDodoCode(message.Text)
+	if err != nil {
+		return []tgbotapi.MessageConfig{
+			{
+				BaseChat: tgbotapi.BaseChat{
+					ChatID: message.Chat.ID,
+				},
+				Text: err.Error(),
+			},
+			{
+				BaseChat: tgbotapi.BaseChat{
+					ChatID:      message.Chat.ID,
+					ReplyMarkup: tgbotapi.ForceReply{ForceReply: true, Selective: true},
+				},
+				Text: message.ReplyToMessage.Text,
+			},
+		}, nil
+	}
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, _projectID)
+	if err != nil {
+		_logger.Error().Err(err).Msg("cmdIslandCrashed newClient")
+		return nil, Error{InnerError: err,
+			ReplyText: "查询队列时出错了",
+		}
+	}
+	defer client.Close()
+	queue, err := storage.GetOnboardQueue(ctx, client, queueID)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, Error{InnerError: err,
+				ReplyText: "队列已取消",
+			}
+		}
+		return nil, Error{InnerError: err,
+			ReplyText: "查询队列时出错了",
+		}
+	}
+	if !queue.IsHost(int64(message.From.ID)) {
+		return nil, Error{ReplyText: "只有岛主才能操作狸"}
+	}
+	if err = queue.SetPassword(password); err != nil {
+		return nil, Error{InnerError: err,
+			ReplyText: "更新队列密码时出错了",
+		}
+	}
+	if err = queue.UpdateSetting(ctx, client, "Password", queue.Password); err != nil {
+		return nil, Error{InnerError: err,
+			ReplyText: "更新队列密码时出错了",
+		}
+	}
+	if err = queue.SetRejoinDeadlines(ctx, client, time.Now().Add(islandCrashRejoinTimeout)); err != nil {
+		_logger.Error().Err(err).Str("queue", queue.ID).Msg("set rejoin deadlines failed")
+	}
+	logQueueEvent(queue, storage.QueueEventCrash, int64(message.From.ID), message.From.UserName)
+	notifyNewPassword(queue, func(password string) string {
+		return fmt.Sprintf("%s 炸岛了狸，新密码如下：\n%s\n请在 %d 分钟内选择马上重新登岛或者结束登岛，超时未选择将回到队首重新排队", queue.Name, password, int(islandCrashRejoinTimeout.Minutes()))
+	}, islandCrashReplyMarkup(queue))
+	tgbot.DeleteMessage(tgbotapi.NewDeleteMessage(message.Chat.ID, message.ReplyToMessage.MessageID))
+	notifyHosts(queue, fmt.Sprintf("新密码：%s\n已发给 %d 位在岛客人，%d 分钟内未回复的客人会回到队首", dodoCodeText(password, notice), queue.LandedLen(), int(islandCrashRejoinTimeout.Minutes())))
+	return
+}
+
+func callbackQueryRejoinIsland(query *tgbotapi.CallbackQuery) (callbackConfig tgbotapi.CallbackConfig, err error) {
+	queueID := query.Data[8:]
+	uid := int64(query.From.ID)
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, _projectID)
+	if err != nil {
+		_logger.Error().Err(err).Msg("create firestore client failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	defer client.Close()
+	queue, err := storage.GetOnboardQueue(ctx, client, queueID)
+	if err != nil {
+		var text = "failed"
+		if status.Code(err) == codes.NotFound {
+			text = "队列已取消"
+		} else {
+			_logger.Error().Err(err).Msg("query queue failed")
+		}
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            text,
+			ShowAlert:       false,
+		}, nil
+	}
+	if err = queue.ClearRejoinDeadline(ctx, client, uid); err != nil {
+		if err.Error() == "not waiting for rejoin" {
+			return tgbotapi.CallbackConfig{
+				CallbackQueryID: query.ID,
+				Text:            "已超过回复时限，您已回到队首重新排队",
+				ShowAlert:       true,
+			}, nil
+		}
+		_logger.Error().Err(err).Msg("clear rejoin deadline failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	var doneBtn = tgbotapi.NewInlineKeyboardButtonData("我要回家啦！", "/done_"+queue.ID)
+	var replyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(doneBtn))
+	tgbot.Send(tgbotapi.NewEditMessageReplyMarkup(query.Message.Chat.ID, query.Message.MessageID, replyMarkup))
+	var name = query.From.UserName
+	if len(name) == 0 {
+		name = query.From.FirstName
+	}
+	notifyHosts(queue, fmt.Sprintf("@%s 正在重新登岛\n队列剩余：%d\n当前在岛：%d", name, queue.Len(), queue.LandedLen()))
+	return tgbotapi.CallbackConfig{
+		CallbackQueryID: query.ID,
+		Text:            "请使用新密码重新登岛",
+		ShowAlert:       false,
+	}, nil
+}
+
+// requeueCrashedGuests 炸岛后超时未回复的在岛客人，回到队首重新排队
+func requeueCrashedGuests(ctx context.Context, client *firestore.Client, queue *storage.OnboardQueue, now time.Time) {
+	var requeued bool
+	for _, g := range queue.ExpiredRejoins(now) {
+		if err := queue.RequeueFront(ctx, client, g.UID, now); err != nil {
+			if err.Error() == "rejoin deadline not expired" {
+				// 客人已经回复，或者已经处理过了
+				continue
+			}
+			_logger.Error().Err(err).Int64("uid", g.UID).Str("queue", queue.ID).Msg("requeue crashed guest failed")
+			continue
+		}
+		requeued = true
+		logQueueEvent(queue, storage.QueueEventRequeue, g.UID, g.Name)
+		_, err := tgbot.Send(tgbotapi.MessageConfig{
+			BaseChat: tgbotapi.BaseChat{
+				ChatID:      g.UID,
+				ReplyMarkup: queueGuestReplyMarkup(queue, now.Unix()),
+			},
+			Text: fmt.Sprintf("您没有在 %d 分钟内回复，已回到前往 %s 的队首，轮到您时会再次通知狸", int(islandCrashRejoinTimeout.Minutes()), queue.Name),
+		})
+		if err != nil {
+			_logger.Info().Err(err).Int64("uid", g.UID).Msg("notify requeued guest failed")
+		}
+		notifyHosts(queue, fmt.Sprintf("@%s 炸岛后没有回复，已回到队首\n队列剩余：%d\n当前在岛：%d", g.Name, queue.Len(), queue.LandedLen()))
+	}
+	if requeued && queue.IsAuto && queue.MaxGuestCount > 0 && queue.LandedLen() < queue.MaxGuestCount {
+		if err := sendNotify(ctx, client, queue); err != nil {
+			_logger.Info().Err(err).Str("queue", queue.ID).Msg("invite next after requeue failed")
+		}
+	}
+}
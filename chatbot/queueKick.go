@@ -0,0 +1,410 @@
+package chatbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"github.com/doylecnn/new-nsfc-bot/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// kickReasonPrompt 踢出客人后，询问岛主原因的提示，后接被踢出客人的 uid
+const kickReasonPrompt = "如需告知被移出的原因，请回复此消息，不需要可忽略。uid："
+
+// guestDisplayName 队列中客人的显示名
+func guestDisplayName(name string, uid int64) string {
+	if len(name) > 0 {
+		return "@" + name
+	}
+	return fmt.Sprintf("tg://user?id=%d", uid)
+}
+
+// parseQueueGuestParams 解析 callback data 中 "队列ID|客人uid" 形式的参数
+func parseQueueGuestParams(params string) (queueID string, uid int64, err error) {
+	sep := strings.SplitN(params, "|", 2)
+	if len(sep) != 2 {
+		return "", 0, errors.New("wrong parameters")
+	}
+	uid, err = strconv.ParseInt(sep[1], 10, 64)
+	return sep[0], uid, err
+}
+
+// queueMembersPageSize 队列成员按钮每页的客人数，Telegram 的 inline keyboard 最多约 100 个按钮
+const queueMembersPageSize = 25
+
+// queuePage 把 total 个客人按 pageSize 分页，返回第 page 页的起止下标；page 超出范围时取最近的一页
+func queuePage(total, pageSize, page int) (start, end, fixedPage, pages int) {
+	pages = (total + pageSize - 1) / pageSize
+	if pages == 0 {
+		pages = 1
+	}
+	if page >= pages {
+		page = pages - 1
+	}
+	if page < 0 {
+		page = 0
+	}
+	start = page * pageSize
+	end = start + pageSize
+	if end > total {
+		end = total
+	}
+	return start, end, page, pages
+}
+
+// queuePageNavRow 翻页按钮，callback data 为 prefix + "队列ID|页码"；extra 放在两个翻页按钮中间
+func queuePageNavRow(prefix, queueID string, page, pages int, extra ...tgbotapi.InlineKeyboardButton) []tgbotapi.InlineKeyboardButton {
+	var row []tgbotapi.InlineKeyboardButton
+	if page > 0 {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("上一页", fmt.Sprintf("%s%s|%d", prefix, queueID, page-1)))
+	}
+	row = append(row, extra...)
+	if page < pages-1 {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("下一页（%d/%d）", page+1, pages), fmt.Sprintf("%s%s|%d", prefix, queueID, page+1)))
+	}
+	return row
+}
+
+// queueMembersReplyMarkup 岛主查看队列成员时，每位客人的踢出/拉黑按钮，按页显示
+func queueMembersReplyMarkup(queue *storage.OnboardQueue, page int) *tgbotapi.InlineKeyboardMarkup {
+	var uids []int64
+	var names []string
+	for _, g := range queue.Landed {
+		uids, names = append(uids, g.UID), append(names, g.Name)
+	}
+	for _, g := range queue.Queue {
+		uids, names = append(uids, g.UID), append(names, g.Name)
+	}
+	if len(uids) == 0 {
+		return nil
+	}
+	start, end, page, pages := queuePage(len(uids), queueMembersPageSize, page)
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i := start; i < end; i++ {
+		name := names[i]
+		if len(name) == 0 {
+			name = strconv.FormatInt(uids[i], 10)
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("踢出 "+name, fmt.Sprintf("/kick_%s|%d", queue.ID, uids[i])),
+			tgbotapi.NewInlineKeyboardButtonData("拉黑 "+name, fmt.Sprintf("/ban_%s|%d", queue.ID, uids[i])),
+		))
+	}
+	if pages > 1 {
+		rows = append(rows, queuePageNavRow("/memberpage_", queue.ID, page, pages))
+	}
+	replyMarkup := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	return &replyMarkup
+}
+
+// callbackQueryMembersPage 队列成员按钮翻页
+func callbackQueryMembersPage(query *tgbotapi.CallbackQuery) (callbackConfig tgbotapi.CallbackConfig, err error) {
+	queueID, page, err := parseQueueGuestParams(query.Data[12:])
+	if err != nil {
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "wrong parameters",
+			ShowAlert:       false,
+		}, nil
+	}
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, _projectID)
+	if err != nil {
+		_logger.Error().Err(err).Msg("create firestore client failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	defer client.Close()
+	queue, failed := getQueueForHost(ctx, client, query, queueID)
+	if failed != nil {
+		return *failed, nil
+	}
+	replyMarkup := queueMembersReplyMarkup(queue, int(page))
+	if replyMarkup == nil {
+		replyMarkup = &tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}}
+	}
+	tgbot.Send(tgbotapi.NewEditMessageReplyMarkup(int64(query.From.ID), query.Message.MessageID, *replyMarkup))
+	err = errors.New("no_alert")
+	return
+}
+
+// callbackQueryKickGuest 岛主将客人移出队列，/ban_ 时同时加入黑名单
+func callbackQueryKickGuest(query *tgbotapi.CallbackQuery) (callbackConfig tgbotapi.CallbackConfig, err error) {
+	var ban = strings.HasPrefix(query.Data, "/ban_")
+	var params string
+	if ban {
+		params = query.Data[5:]
+	} else {
+		params = query.Data[6:]
+	}
+	queueID, guestUID, err := parseQueueGuestParams(params)
+	if err != nil {
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "wrong parameters",
+			ShowAlert:       false,
+		}, nil
+	}
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, _projectID)
+	if err != nil {
+		_logger.Error().Err(err).Msg("create firestore client failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	defer client.Close()
+	queue, err := storage.GetOnboardQueue(ctx, client, queueID)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return tgbotapi.CallbackConfig{
+				CallbackQueryID: query.ID,
+				Text:            "队列已取消",
+				ShowAlert:       false,
+			}, nil
+		}
+		_logger.Error().Err(err).Msg("query queue failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	if !queue.IsHost(int64(query.From.ID)) {
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "只有岛主才能移出客人狸",
+			ShowAlert:       false,
+		}, nil
+	}
+	name, inQueue := queue.GetGuestName(guestUID)
+	if inQueue {
+		if err = queue.Remove(ctx, client, guestUID); err != nil {
+			_logger.Error().Err(err).Int64("uid", guestUID).Msg("kick guest failed")
+			return tgbotapi.CallbackConfig{
+				CallbackQueryID: query.ID,
+				Text:            "failed",
+				ShowAlert:       false,
+			}, nil
+		}
+	} else if !ban {
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "该客人已不在队列中",
+			ShowAlert:       false,
+		}, nil
+	}
+	if ban {
+		if err = storage.BanGuest(ctx, queue.OwnerID, guestUID, name); err != nil {
+			_logger.Error().Err(err).Int64("uid", guestUID).Msg("ban guest failed")
+			return tgbotapi.CallbackConfig{
+				CallbackQueryID: query.ID,
+				Text:            "failed",
+				ShowAlert:       false,
+			}, nil
+		}
+	}
+	if inQueue {
+		recordGuestEvent(guestUID, storage.GuestEventKicked)
+		logQueueEvent(queue, storage.QueueEventKick, guestUID, name)
+		promoteWaitlist(ctx, client, queue)
+		var guestText = fmt.Sprintf("您已被岛主移出前往 %s 的队列。", queue.Name)
+		if ban {
+			guestText = fmt.Sprintf("您已被岛主移出前往 %s 的队列，并且无法再加入该岛主的队列。", queue.Name)
+		}
+		if _, err = tgbot.Send(tgbotapi.NewMessage(guestUID, guestText)); err != nil {
+			_logger.Info().Err(err).Int64("uid", guestUID).Msg("notify kicked guest failed")
+		}
+		if queue.IsAuto && queue.MaxGuestCount > 0 && queue.LandedLen() < queue.MaxGuestCount {
+			sendNotify(ctx, client, queue)
+		}
+	}
+
+	var replyText = fmt.Sprintf("已将 %s 移出队列", guestDisplayName(name, guestUID))
+	if ban {
+		replyText += "并加入黑名单，/banlist 管理黑名单"
+	}
+	replyText += fmt.Sprintf("\n队列剩余：%d\n当前在岛：%d", queue.Len(), queue.LandedLen())
+	notifyHosts(queue, replyText)
+	if inQueue {
+		tgbot.Send(tgbotapi.MessageConfig{
+			BaseChat: tgbotapi.BaseChat{
+				ChatID:      int64(query.From.ID),
+				ReplyMarkup: tgbotapi.ForceReply{ForceReply: true, Selective: true},
+			},
+			Text: fmt.Sprintf("%s%d", kickReasonPrompt, guestUID),
+		})
+	}
+	err = errors.New("no_alert")
+	return
+}
+
+// cmdSendKickReason 岛主回复原因后，转告被移出的客人
+func cmdSendKickReason(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig, err error) {
+	guestUID, err := strconv.ParseInt(strings.TrimPrefix(message.ReplyToMessage.Text, kickReasonPrompt), 10, 64)
+	if err != nil {
+		return nil, Error{InnerError: err,
+			ReplyText: "无法识别被移出的客人狸",
+		}
+	}
+	reason := strings.TrimSpace(message.Text)
+	if len(reason) == 0 {
+		return
+	}
+	if _, err = tgbot.Send(tgbotapi.NewMessage(guestUID, "岛主移出您的原因："+reason)); err != nil {
+		return nil, Error{InnerError: err,
+			ReplyText: "转告原因失败狸",
+		}
+	}
+	tgbot.DeleteMessage(tgbotapi.NewDeleteMessage(message.Chat.ID, message.ReplyToMessage.MessageID))
+	return []tgbotapi.MessageConfig{tgbotapi.NewMessage(message.Chat.ID, "已转告原因狸")}, nil
+}
+
+// bannableOwnerIDs 可以管理黑名单的岛主：自己，以及自己协作的队列的岛主
+func bannableOwnerIDs(ctx context.Context, uid int64) (ownerIDs []int64, err error) {
+	ownerIDs = []int64{uid}
+	client, err := firestore.NewClient(ctx, _projectID)
+	if err != nil {
+		return
+	}
+	defer client.Close()
+	queues, err := storage.GetCoHostedQueues(ctx, client, uid)
+	if err != nil {
+		return
+	}
+	var seen = map[int64]bool{uid: true}
+	for _, q := range queues {
+		if !seen[q.OwnerID] {
+			seen[q.OwnerID] = true
+			ownerIDs = append(ownerIDs, q.OwnerID)
+		}
+	}
+	return
+}
+
+// canManageBans uid 是否可以管理 ownerID 的黑名单
+func canManageBans(ctx context.Context, uid, ownerID int64) (bool, error) {
+	ownerIDs, err := bannableOwnerIDs(ctx, uid)
+	if err != nil {
+		return false, err
+	}
+	for _, id := range ownerIDs {
+		if id == ownerID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// cmdBanList 列出岛主的黑名单，协作岛主可以看到所协作队列的岛主的黑名单
+func cmdBanList(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig, err error) {
+	ctx := context.Background()
+	uid := int64(message.From.ID)
+	ownerIDs, err := bannableOwnerIDs(ctx, uid)
+	if err != nil {
+		return nil, Error{InnerError: err,
+			ReplyText: "查询黑名单时出错狸",
+		}
+	}
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var lines []string
+	for _, ownerID := range ownerIDs {
+		guests, err := storage.GetBannedGuests(ctx, ownerID)
+		if err != nil {
+			return nil, Error{InnerError: err,
+				ReplyText: "查询黑名单时出错狸",
+			}
+		}
+		if len(guests) == 0 {
+			continue
+		}
+		if ownerID != uid {
+			lines = append(lines, fmt.Sprintf("协作的岛主 %s 的黑名单：", guestDisplayName("", ownerID)))
+		}
+		for _, g := range guests {
+			name := guestDisplayName(g.Name, g.UID)
+			lines = append(lines, name)
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("移出黑名单 "+name, fmt.Sprintf("/unban_%d|%d", ownerID, g.UID))))
+		}
+	}
+	if len(lines) == 0 {
+		return []tgbotapi.MessageConfig{tgbotapi.NewMessage(message.Chat.ID, "黑名单是空的狸")}, nil
+	}
+	return []tgbotapi.MessageConfig{{
+			BaseChat: tgbotapi.BaseChat{
+				ChatID:      message.Chat.ID,
+				ReplyMarkup: tgbotapi.NewInlineKeyboardMarkup(rows...),
+			},
+			Text: "以下客人无法加入您的队列：\n" + strings.Join(lines, "\n"),
+		}},
+		nil
+}
+
+func callbackQueryUnbanGuest(query *tgbotapi.CallbackQuery) (callbackConfig tgbotapi.CallbackConfig, err error) {
+	uid := int64(query.From.ID)
+	var ownerID = uid
+	params := strings.SplitN(query.Data[7:], "|", 2)
+	if len(params) == 2 {
+		if ownerID, err = strconv.ParseInt(params[0], 10, 64); err != nil {
+			return tgbotapi.CallbackConfig{
+				CallbackQueryID: query.ID,
+				Text:            "wrong parameters",
+				ShowAlert:       false,
+			}, nil
+		}
+	}
+	guestUID, err := strconv.ParseInt(params[len(params)-1], 10, 64)
+	if err != nil {
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "wrong parameters",
+			ShowAlert:       false,
+		}, nil
+	}
+	ctx := context.Background()
+	if ownerID != uid {
+		ok, err := canManageBans(ctx, uid, ownerID)
+		if err != nil {
+			_logger.Error().Err(err).Int64("uid", uid).Msg("query co-hosted queues failed")
+			return tgbotapi.CallbackConfig{
+				CallbackQueryID: query.ID,
+				Text:            "failed",
+				ShowAlert:       false,
+			}, nil
+		}
+		if !ok {
+			return tgbotapi.CallbackConfig{
+				CallbackQueryID: query.ID,
+				Text:            "只有岛主才能管理黑名单狸",
+				ShowAlert:       false,
+			}, nil
+		}
+	}
+	if err = storage.UnbanGuest(ctx, ownerID, guestUID); err != nil {
+		_logger.Error().Err(err).Int64("uid", guestUID).Msg("unban guest failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	tgbot.DeleteMessage(tgbotapi.NewDeleteMessage(uid, query.Message.MessageID))
+	return tgbotapi.CallbackConfig{
+		CallbackQueryID: query.ID,
+		Text:            "已移出黑名单",
+		ShowAlert:       false,
+	}, nil
+}
@@ -0,0 +1,52 @@
+package chatbot
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/doylecnn/new-nsfc-bot/storage"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// logQueueEvent 记录队列事件，失败时只记录日志，不影响排队；稍后刷新分享出去的队列卡片
+func logQueueEvent(queue *storage.OnboardQueue, eventType string, uid int64, name string) {
+	defer scheduleQueueCardRefresh(queue)
+	err := storage.RecordQueueEvent(context.Background(), queue, storage.QueueEvent{
+		Type:        eventType,
+		UID:         uid,
+		Name:        name,
+		Time:        time.Now(),
+		QueueLength: queue.Len(),
+	})
+	if err != nil {
+		_logger.Error().Err(err).Str("queue", queue.ID).Str("event", eventType).Msg("record queue event failed")
+	}
+}
+
+// queueSummaryText 队列解散时发给岛主的统计
+func queueSummaryText(queue *storage.OnboardQueue, summary storage.QueueSummary) string {
+	var avg = "暂无"
+	if summary.AverageVisit > 0 {
+		avg = fmt.Sprintf("%.1f 分钟", summary.AverageVisit.Minutes())
+	}
+	return fmt.Sprintf("前往 %s 的队列已解散，本次统计：\n接待客人：%d 位\n超时未到：%d 位\n平均登岛时长：%s\n最长排队：%d 人\n完整记录：https://%s/queuelogs/%s", queue.Name, summary.Served, summary.NoShows, avg, summary.PeakLength, _domain, queue.ID)
+}
+
+// sendQueueSummary 队列解散后，统计本次队列并发给岛主
+func sendQueueSummary(queue *storage.OnboardQueue) {
+	ctx := context.Background()
+	events, err := storage.GetQueueEvents(ctx, queue.ID)
+	if err != nil {
+		_logger.Error().Err(err).Str("queue", queue.ID).Msg("get queue events failed")
+		return
+	}
+	summary := storage.SummarizeQueueEvents(events)
+	if err = storage.FinishQueueLog(ctx, queue, summary); err != nil {
+		_logger.Error().Err(err).Str("queue", queue.ID).Msg("save queue summary failed")
+	}
+	if _, err = tgbot.Send(tgbotapi.NewMessage(queue.OwnerID, queueSummaryText(queue, summary))); err != nil {
+		_logger.Info().Err(err).Int64("uid", queue.OwnerID).Msg("send queue summary failed")
+	}
+}
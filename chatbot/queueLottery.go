@@ -0,0 +1,106 @@
+package chatbot
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/doylecnn/new-nsfc-bot/storage"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// lotteryVerifyText 说明如何验证抽签结果
+const lotteryVerifyText = "验证方法：确认种子的 sha256 与报名时公布的哈希一致，把全部编号按字典序排序，以种子（16 进制）作为 Go math/rand.NewSource 的种子，调用 rand.Shuffle 打乱，即为抽签顺序"
+
+// lotterySlotsText 抽签名额
+func lotterySlotsText(queue *storage.OnboardQueue) string {
+	if queue.LotterySlots == 0 {
+		return "不限，所有人抽签排序"
+	}
+	return fmt.Sprintf("%d 人", queue.LotterySlots)
+}
+
+// lotteryStatusText 抽签报名的状态，公布种子哈希，抽签后可以用种子验证
+func lotteryStatusText(queue *storage.OnboardQueue, now time.Time) string {
+	var state = "报名已截止，即将抽签"
+	if queue.IsLotteryOpen(now) {
+		state = fmt.Sprintf("报名中，约 %d 分钟后截止", int(math.Ceil(queue.LotteryEndsAt.Sub(now).Minutes())))
+	}
+	return fmt.Sprintf("%s，名额：%s\n种子哈希：%s", state, lotterySlotsText(queue), queue.LotterySeedHash())
+}
+
+// lotteryTokenText 抽签报名时告诉客人自己的编号，抽签后用来核对结果
+func lotteryTokenText(queue *storage.OnboardQueue, uid int64) string {
+	if !queue.IsLotteryPending() {
+		return ""
+	}
+	return "\n您的抽签编号：" + queue.LotteryToken(uid)
+}
+
+// lotteryResultText 抽签结果中公布的种子和全部编号
+func lotteryResultText(seed, seedHash string, tokens []string) string {
+	sort.Strings(tokens)
+	return fmt.Sprintf("种子：%s\n报名时公布的哈希：%s\n全部编号：%s\n%s", seed, seedHash, strings.Join(tokens, " "), lotteryVerifyText)
+}
+
+// drawQueueLottery 抽签报名截止后抽签，按抽签顺序排队，通知抽中和没抽中的客人
+func drawQueueLottery(ctx context.Context, client *firestore.Client, queue *storage.OnboardQueue, now time.Time) {
+	if !queue.IsLotteryPending() || queue.IsLotteryOpen(now) {
+		return
+	}
+	var seed, seedHash = queue.LotterySeed, queue.LotterySeedHash()
+	winners, losers, err := queue.DrawLottery(ctx, client, now)
+	if err != nil {
+		if err.Error() == "lottery not started" || err.Error() == "lottery is open" {
+			// 已经抽过签，或者岛主延长了报名时间
+			return
+		}
+		_logger.Error().Err(err).Str("queue", queue.ID).Msg("draw lottery failed")
+		return
+	}
+	var tokens []string
+	for _, g := range winners {
+		tokens = append(tokens, queue.LotteryToken(g.UID))
+	}
+	for _, g := range losers {
+		tokens = append(tokens, queue.LotteryToken(g.UID))
+	}
+	var resultText = lotteryResultText(seed, seedHash, tokens)
+	for i, g := range winners {
+		_, err = tgbot.Send(tgbotapi.MessageConfig{
+			BaseChat: tgbotapi.BaseChat{
+				ChatID:      g.UID,
+				ReplyMarkup: queueGuestReplyMarkup(queue, now.Unix()),
+			},
+			Text: fmt.Sprintf("前往 %s 的抽签结果出来了，您抽中了狸！\n您的编号：%s\n当前位置：%d/%d%s\n\n%s", queue.Name, queue.LotteryToken(g.UID), i+1, queue.Len(), estimatedWaitText(queue, i+1), resultText),
+		})
+		if err != nil {
+			_logger.Info().Err(err).Int64("uid", g.UID).Msg("notify lottery winner failed")
+		}
+	}
+	var joinBtn = tgbotapi.NewInlineKeyboardButtonData("重新排队："+queue.Name, "/join_"+queue.ID)
+	for _, g := range losers {
+		logQueueEvent(queue, storage.QueueEventLottery, g.UID, g.Name)
+		_, err = tgbot.Send(tgbotapi.MessageConfig{
+			BaseChat: tgbotapi.BaseChat{
+				ChatID:      g.UID,
+				ReplyMarkup: tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(joinBtn)),
+			},
+			Text: fmt.Sprintf("很遗憾，您没有抽中前往 %s 的名额，已离开队列狸。\n您的编号：%s\n如果还想前往，可以重新排在队尾。\n\n%s", queue.Name, queue.LotteryToken(g.UID), resultText),
+		})
+		if err != nil {
+			_logger.Info().Err(err).Int64("uid", g.UID).Msg("notify lottery loser failed")
+		}
+	}
+	notifyHosts(queue, fmt.Sprintf("抽签完成：报名 %d 人，抽中 %d 人，未抽中 %d 人\n队列剩余：%d\n当前在岛：%d\n\n%s", len(tokens), len(winners), len(losers), queue.Len(), queue.LandedLen(), resultText))
+	if queue.IsAuto && queue.Len() > 0 && queue.LandedLen() < queue.MaxGuestCount {
+		if err = sendNotify(ctx, client, queue); err != nil {
+			_logger.Info().Err(err).Str("queue", queue.ID).Msg("invite after lottery failed")
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package chatbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"github.com/doylecnn/new-nsfc-bot/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// notifyQueueGuests 通知队列中、岛上以及候补的所有客人
+func notifyQueueGuests(queue *storage.OnboardQueue, text string) {
+	var uids []int64
+	for _, g := range queue.Queue {
+		uids = append(uids, g.UID)
+	}
+	for _, g := range queue.Landed {
+		uids = append(uids, g.UID)
+	}
+	for _, g := range queue.Waitlist {
+		uids = append(uids, g.UID)
+	}
+	for _, uid := range uids {
+		if _, err := tgbot.Send(tgbotapi.NewMessage(uid, text)); err != nil {
+			_logger.Info().Err(err).Int64("uid", uid).Str("queue", queue.ID).Msg("notify queue guest failed")
+		}
+	}
+}
+
+// callbackQueryPauseQueue 岛主暂停/恢复邀请，暂停期间客人仍可加入排队
+func callbackQueryPauseQueue(query *tgbotapi.CallbackQuery) (callbackConfig tgbotapi.CallbackConfig, err error) {
+	var paused = strings.HasPrefix(query.Data, "/pause_")
+	queueID := query.Data[strings.Index(query.Data, "_")+1:]
+	uid := int64(query.From.ID)
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, _projectID)
+	if err != nil {
+		_logger.Error().Err(err).Msg("create firestore client failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	defer client.Close()
+	queue, err := storage.GetOnboardQueue(ctx, client, queueID)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return tgbotapi.CallbackConfig{
+				CallbackQueryID: query.ID,
+				Text:            "队列已取消",
+				ShowAlert:       false,
+			}, nil
+		}
+		_logger.Error().Err(err).Msg("query queue failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	if !queue.IsHost(uid) {
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "只有岛主才能操作狸",
+			ShowAlert:       false,
+		}, nil
+	}
+	if queue.Paused == paused {
+		var text = "队列已经在邀请中了"
+		if paused {
+			text = "队列已经暂停邀请了"
+		}
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            text,
+			ShowAlert:       false,
+		}, nil
+	}
+	if err = queue.SetPaused(ctx, client, paused); err != nil {
+		_logger.Error().Err(err).Str("queue", queueID).Msg("set queue paused failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	if paused {
+		logQueueEvent(queue, storage.QueueEventPause, uid, query.From.UserName)
+		notifyHosts(queue, fmt.Sprintf("队列已暂停邀请，客人仍可加入排队\n队列剩余：%d\n当前在岛：%d", queue.Len(), queue.LandedLen()))
+		notifyQueueGuests(queue, fmt.Sprintf("前往 %s 的队列暂停邀请了狸，您的位置会保留，恢复后会按顺序继续邀请", queue.Name))
+	} else {
+		logQueueEvent(queue, storage.QueueEventResume, uid, query.From.UserName)
+		notifyHosts(queue, fmt.Sprintf("队列已恢复邀请\n队列剩余：%d\n当前在岛：%d", queue.Len(), queue.LandedLen()))
+		notifyQueueGuests(queue, fmt.Sprintf("前往 %s 的队列恢复邀请了狸", queue.Name))
+		if queue.IsAuto && queue.Len() > 0 && queue.LandedLen() < queue.MaxGuestCount {
+			if err = sendNotify(ctx, client, queue); err != nil {
+				_logger.Info().Err(err).Str("queue", queueID).Msg("invite next after resume failed")
+			}
+		}
+	}
+	err = errors.New("no_alert")
+	return
+}
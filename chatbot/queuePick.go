@@ -0,0 +1,292 @@
+package chatbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"github.com/doylecnn/new-nsfc-bot/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// queuePickPageSize 选择下一位时每页的客人数，每位客人 3 个按钮，不能超过 Telegram 的按钮上限
+const queuePickPageSize = 25
+
+// queuePickReplyMarkup 岛主选择下一位/调整顺序的按钮，按页显示
+func queuePickReplyMarkup(queue *storage.OnboardQueue, page int) tgbotapi.InlineKeyboardMarkup {
+	start, end, page, pages := queuePage(queue.Len(), queuePickPageSize, page)
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i := start; i < end; i++ {
+		g := queue.Queue[i]
+		name := g.Name
+		if len(name) == 0 {
+			name = strconv.FormatInt(g.UID, 10)
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%d. 有请 %s", i+1, name), fmt.Sprintf("/picknext_%s|%d", queue.ID, g.UID)),
+			tgbotapi.NewInlineKeyboardButtonData("↑", fmt.Sprintf("/moveup_%s|%d", queue.ID, g.UID)),
+			tgbotapi.NewInlineKeyboardButtonData("↓", fmt.Sprintf("/movedown_%s|%d", queue.ID, g.UID)),
+		))
+	}
+	rows = append(rows, queuePageNavRow("/pickpage_", queue.ID, page, pages, tgbotapi.NewInlineKeyboardButtonData("完成", "/cancel")))
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// callbackQueryPickPage 选择下一位的列表翻页
+func callbackQueryPickPage(query *tgbotapi.CallbackQuery) (callbackConfig tgbotapi.CallbackConfig, err error) {
+	queueID, page, err := parseQueueGuestParams(query.Data[10:])
+	if err != nil {
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "wrong parameters",
+			ShowAlert:       false,
+		}, nil
+	}
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, _projectID)
+	if err != nil {
+		_logger.Error().Err(err).Msg("create firestore client failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	defer client.Close()
+	queue, failed := getQueueForHost(ctx, client, query, queueID)
+	if failed != nil {
+		return *failed, nil
+	}
+	tgbot.Send(tgbotapi.NewEditMessageReplyMarkup(int64(query.From.ID), query.Message.MessageID, queuePickReplyMarkup(queue, int(page))))
+	err = errors.New("no_alert")
+	return
+}
+
+// getQueueForHost 获得队列，并确认操作者是岛主或协作岛主
+func getQueueForHost(ctx context.Context, client *firestore.Client, query *tgbotapi.CallbackQuery, queueID string) (queue *storage.OnboardQueue, callbackConfig *tgbotapi.CallbackConfig) {
+	queue, err := storage.GetOnboardQueue(ctx, client, queueID)
+	if err != nil {
+		var text = "failed"
+		if status.Code(err) == codes.NotFound {
+			text = "队列已取消"
+		} else {
+			_logger.Error().Err(err).Msg("query queue failed")
+		}
+		return nil, &tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            text,
+			ShowAlert:       false,
+		}
+	}
+	if !queue.IsHost(int64(query.From.ID)) {
+		return nil, &tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "只有岛主才能操作狸",
+			ShowAlert:       false,
+		}
+	}
+	return queue, nil
+}
+
+// callbackQueryPickGuests 列出排队中的客人，供岛主选择下一位或调整顺序
+func callbackQueryPickGuests(query *tgbotapi.CallbackQuery) (callbackConfig tgbotapi.CallbackConfig, err error) {
+	queueID := query.Data[6:]
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, _projectID)
+	if err != nil {
+		_logger.Error().Err(err).Msg("create firestore client failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	defer client.Close()
+	queue, failed := getQueueForHost(ctx, client, query, queueID)
+	if failed != nil {
+		return *failed, nil
+	}
+	if queue.Len() == 0 {
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "并没有在等候的访客……",
+			ShowAlert:       false,
+		}, nil
+	}
+	_, err = tgbot.Send(tgbotapi.MessageConfig{
+		BaseChat: tgbotapi.BaseChat{
+			ChatID:      int64(query.From.ID),
+			ReplyMarkup: queuePickReplyMarkup(queue, 0),
+		},
+		Text: "请选择下一位，或使用 ↑ ↓ 调整排队顺序",
+	})
+	if err != nil {
+		_logger.Error().Err(err).Msg("send pick list failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	err = errors.New("no_alert")
+	return
+}
+
+// callbackQueryPickNextGuest 岛主选择下一位客人，并邀请其登岛
+func callbackQueryPickNextGuest(query *tgbotapi.CallbackQuery) (callbackConfig tgbotapi.CallbackConfig, err error) {
+	queueID, guestUID, err := parseQueueGuestParams(query.Data[10:])
+	if err != nil {
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "wrong parameters",
+			ShowAlert:       false,
+		}, nil
+	}
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, _projectID)
+	if err != nil {
+		_logger.Error().Err(err).Msg("create firestore client failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	defer client.Close()
+	queue, failed := getQueueForHost(ctx, client, query, queueID)
+	if failed != nil {
+		return *failed, nil
+	}
+	if queue.IsLotteryPending() {
+		// 抽签会重新排列队列，先检查，不要改动顺序
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "抽签报名中，抽签后会重新排列队列，暂时不能挑选访客",
+			ShowAlert:       false,
+		}, nil
+	}
+	if err = queue.Move(ctx, client, guestUID, 0); err != nil {
+		if err.Error() == "not join in this queue" {
+			return tgbotapi.CallbackConfig{
+				CallbackQueryID: query.ID,
+				Text:            "该客人已不在队列中",
+				ShowAlert:       false,
+			}, nil
+		}
+		_logger.Error().Err(err).Msg("move guest failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	if err = sendNotify(ctx, client, queue); err != nil {
+		if err.Error() == "queue is paused" {
+			return tgbotapi.CallbackConfig{
+				CallbackQueryID: query.ID,
+				Text:            "已将选中的访客移到队首，队列暂停邀请中，恢复后会先邀请 TA",
+				ShowAlert:       false,
+			}, nil
+		}
+		_logger.Error().Err(err).Msg("notify picked guest failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	tgbot.DeleteMessage(tgbotapi.NewDeleteMessage(int64(query.From.ID), query.Message.MessageID))
+	return tgbotapi.CallbackConfig{
+		CallbackQueryID: query.ID,
+		Text:            "成功通知选中的访客",
+		ShowAlert:       false,
+	}, nil
+}
+
+// callbackQueryMoveGuest 岛主将客人在队列中上移/下移一位
+func callbackQueryMoveGuest(query *tgbotapi.CallbackQuery) (callbackConfig tgbotapi.CallbackConfig, err error) {
+	var offset = -1
+	var params string
+	if strings.HasPrefix(query.Data, "/moveup_") {
+		params = query.Data[8:]
+	} else {
+		offset = 1
+		params = query.Data[10:]
+	}
+	queueID, guestUID, err := parseQueueGuestParams(params)
+	if err != nil {
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "wrong parameters",
+			ShowAlert:       false,
+		}, nil
+	}
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, _projectID)
+	if err != nil {
+		_logger.Error().Err(err).Msg("create firestore client failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	defer client.Close()
+	queue, failed := getQueueForHost(ctx, client, query, queueID)
+	if failed != nil {
+		return *failed, nil
+	}
+	var from = -1
+	for i, g := range queue.Queue {
+		if g.UID == guestUID {
+			from = i
+			break
+		}
+	}
+	if from < 0 {
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "该客人已不在队列中",
+			ShowAlert:       false,
+		}, nil
+	}
+	if from+offset < 0 || from+offset >= queue.Len() {
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "已经到头了狸",
+			ShowAlert:       false,
+		}, nil
+	}
+	var upcoming []int64
+	for i := 0; i < 2 && i < queue.Len(); i++ {
+		upcoming = append(upcoming, queue.Queue[i].UID)
+	}
+	if err = queue.Move(ctx, client, guestUID, from+offset); err != nil {
+		_logger.Error().Err(err).Msg("move guest failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	for i, uid := range upcoming {
+		if i >= queue.Len() || queue.Queue[i].UID != uid {
+			notifyUpcomingGuests(queue)
+			break
+		}
+	}
+	// 停留在被移动的客人所在的那一页
+	var replyMarkup = queuePickReplyMarkup(queue, (from+offset)/queuePickPageSize)
+	tgbot.Send(tgbotapi.NewEditMessageReplyMarkup(int64(query.From.ID), query.Message.MessageID, replyMarkup))
+	return tgbotapi.CallbackConfig{
+		CallbackQueryID: query.ID,
+		Text:            "已调整顺序",
+		ShowAlert:       false,
+	}, nil
+}
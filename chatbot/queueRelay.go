@@ -0,0 +1,167 @@
+package chatbot
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"github.com/doylecnn/new-nsfc-bot/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	// relayGuestPrompt 转达给岛主的客人消息前缀，后接“客人编号|队列 ID”，岛主回复这条消息即可转达给客人
+	relayGuestPrompt = "客人消息，直接回复本条消息即可转达给客人。客人编号："
+	// relayHostPrompt 转达给客人的岛主消息前缀，客人回复这条消息或直接发消息给 bot 即可转达给岛主
+	relayHostPrompt = "岛主回复，直接回复本条消息即可转达给岛主："
+)
+
+// relayGuestToken 转达消息时代替客人用户名的编号
+func relayGuestToken(queueID string, uid int64) string {
+	sum := sha256.Sum256([]byte(queueID + "|" + strconv.FormatInt(uid, 10)))
+	return hex.EncodeToString(sum[:])[:6]
+}
+
+// HandleRelayMessage 在岛客人私聊 bot 的消息，转达给岛主
+func (c ChatBot) HandleRelayMessage(message *tgbotapi.Message) {
+	replies, err := cmdRelayToHost(message)
+	if err != nil {
+		c.logger.Error().Err(err).Msg("cmdRelayToHost")
+		if e, ok := err.(Error); ok && len(e.ReplyText) > 0 {
+			replies = append(replies, tgbotapi.NewMessage(message.Chat.ID, e.ReplyText))
+		}
+	}
+	for _, reply := range replies {
+		if _, err := c.TgBotClient.Send(reply); err != nil {
+			c.logger.Error().Err(err).Msg("cmdRelayToHost send message")
+		}
+	}
+}
+
+// cmdRelayToHost 客人被邀请登岛后，发给 bot 的文字消息匿名转达给岛主；不在任何岛上的用户不回复
+func cmdRelayToHost(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig, err error) {
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, _projectID)
+	if err != nil {
+		return nil, Error{InnerError: err,
+			ReplyText: "转达消息失败",
+		}
+	}
+	defer client.Close()
+	uid := int64(message.From.ID)
+	queue, err := storage.GetLandedQueue(ctx, client, uid)
+	if err != nil {
+		return nil, Error{InnerError: err,
+			ReplyText: "转达消息失败",
+		}
+	}
+	if queue == nil {
+		if message.ReplyToMessage != nil {
+			return []tgbotapi.MessageConfig{tgbotapi.NewMessage(message.Chat.ID, "您已不在岛上，队列结束后无法再转达消息狸")}, nil
+		}
+		return
+	}
+	if len(message.Text) == 0 {
+		return []tgbotapi.MessageConfig{tgbotapi.NewMessage(message.Chat.ID, "目前只能转达文字消息狸")}, nil
+	}
+	_, err = tgbot.Send(tgbotapi.MessageConfig{
+		BaseChat: tgbotapi.BaseChat{
+			ChatID:      queue.OwnerID,
+			ReplyMarkup: tgbotapi.ForceReply{ForceReply: true, Selective: true},
+		},
+		Text: fmt.Sprintf("%s%s|%s\n前往 %s 的客人说：\n%s", relayGuestPrompt, relayGuestToken(queue.ID, uid), queue.ID, queue.Name, message.Text),
+	})
+	if err != nil {
+		return nil, Error{InnerError: err,
+			ReplyText: "转达消息失败",
+		}
+	}
+	return []tgbotapi.MessageConfig{{
+			BaseChat: tgbotapi.BaseChat{
+				ChatID:              message.Chat.ID,
+				ReplyToMessageID:    message.MessageID,
+				DisableNotification: true,
+			},
+			Text: "已转达给岛主狸",
+		}},
+		nil
+}
+
+// cmdRelayToGuest 岛主回复客人消息后，匿名转达给客人；客人离岛或队列结束后不再转达
+func cmdRelayToGuest(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig, err error) {
+	if !message.Chat.IsPrivate() {
+		return
+	}
+	firstLine := strings.SplitN(strings.TrimPrefix(message.ReplyToMessage.Text, relayGuestPrompt), "\n", 2)[0]
+	params := strings.Split(firstLine, "|")
+	if len(params) != 2 {
+		return nil, Error{ReplyText: "无法识别客人编号狸"}
+	}
+	token, queueID := params[0], params[1]
+	if len(message.Text) == 0 {
+		return []tgbotapi.MessageConfig{tgbotapi.NewMessage(message.Chat.ID, "目前只能转达文字消息狸")}, nil
+	}
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, _projectID)
+	if err != nil {
+		return nil, Error{InnerError: err,
+			ReplyText: "转达消息失败",
+		}
+	}
+	defer client.Close()
+	queue, err := storage.GetOnboardQueue(ctx, client, queueID)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, Error{InnerError: err,
+				ReplyText: "队列已结束，无法再转达消息狸",
+			}
+		}
+		return nil, Error{InnerError: err,
+			ReplyText: "转达消息失败",
+		}
+	}
+	if queue.Dismissed {
+		return nil, Error{ReplyText: "队列已结束，无法再转达消息狸"}
+	}
+	if !queue.IsHost(int64(message.From.ID)) {
+		return nil, Error{ReplyText: "只有岛主才能回复客人狸"}
+	}
+	var guestUID int64
+	for _, g := range queue.Landed {
+		if relayGuestToken(queue.ID, g.UID) == token {
+			guestUID = g.UID
+			break
+		}
+	}
+	if guestUID == 0 {
+		return nil, Error{ReplyText: "客人已经离岛，无法再转达消息狸"}
+	}
+	_, err = tgbot.Send(tgbotapi.MessageConfig{
+		BaseChat: tgbotapi.BaseChat{
+			ChatID:      guestUID,
+			ReplyMarkup: tgbotapi.ForceReply{ForceReply: true, Selective: true},
+		},
+		Text: fmt.Sprintf("%s%s\n%s", relayHostPrompt, queue.Name, message.Text),
+	})
+	if err != nil {
+		return nil, Error{InnerError: err,
+			ReplyText: "转达消息失败",
+		}
+	}
+	return []tgbotapi.MessageConfig{{
+			BaseChat: tgbotapi.BaseChat{
+				ChatID:              message.Chat.ID,
+				ReplyToMessageID:    message.MessageID,
+				DisableNotification: true,
+			},
+			Text: "已转达给客人狸",
+		}},
+		nil
+}
@@ -0,0 +1,469 @@
+package chatbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/doylecnn/new-nsfc-bot/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	// queueSchedulePasswordPrompt 预约的队列到开放时间时，要求岛主回复密码的提示，后接预约编号
+	queueSchedulePasswordPrompt = "预约的队列到开放时间了狸，请回复开岛密码。预约编号："
+	// queuePreJoinURLPrefix 预先登记排队的链接前缀
+	queuePreJoinURLPrefix = "https://t.me/NS_FC_bot?start=prejoin_"
+	// queueScheduleExpire 到开放时间后岛主迟迟没有输入密码，超过此时间自动取消预约
+	queueScheduleExpire = 2 * time.Hour
+	queueScheduleUsage  = "/queue at [时:分] 预约在此时间开放队列，客人可以提前登记排队\n/queue at [时:分] [最大客人数] 预约开放半自动队列"
+)
+
+// queueScheduleReplyMarkup 预约的操作面板
+func queueScheduleReplyMarkup(schedule *storage.QueueSchedule) tgbotapi.InlineKeyboardMarkup {
+	var shareBtn = tgbotapi.NewInlineKeyboardButtonSwitch("分享预约："+schedule.Name, "/shareschedule_"+schedule.ID)
+	var cancelBtn = tgbotapi.NewInlineKeyboardButtonData("取消预约", "/cancelschedule_"+schedule.ID)
+	return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(shareBtn), tgbotapi.NewInlineKeyboardRow(cancelBtn))
+}
+
+// cmdScheduleIslandQueue 预约在指定时间开放队列，时间按岛屿所在时区计算
+func cmdScheduleIslandQueue(message *tgbotapi.Message, island *storage.Island, uid int, args []string) (replyMessage []tgbotapi.MessageConfig, err error) {
+	if len(args) == 0 || len(args) > 2 {
+		return nil, Error{ReplyText: queueScheduleUsage}
+	}
+	t, err := time.Parse("15:04", args[0])
+	if err != nil {
+		return nil, Error{InnerError: err,
+			ReplyText: "开放时间请使用 时:分 的格式，例如 20:00",
+		}
+	}
+	var maxGuestCount = 0
+	if len(args) == 2 {
+		maxGuestCount, err = strconv.Atoi(args[1])
+		if err != nil || maxGuestCount < 1 || maxGuestCount > 7 {
+			return nil, Error{InnerError: err,
+				ReplyText: "同时登岛客人数必须是数字，取值范围 [1，7]",
+			}
+		}
+	}
+	loc := island.Timezone.Location()
+	now := time.Now().In(loc)
+	startTime := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, loc)
+	if !startTime.After(now) {
+		startTime = startTime.AddDate(0, 0, 1)
+	}
+
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, _projectID)
+	if err != nil {
+		_logger.Error().Err(err).Msg("cmdScheduleIslandQueue newClient")
+		return nil, Error{InnerError: err,
+			ReplyText: "预约队列时出错狸",
+		}
+	}
+	defer client.Close()
+	schedule, err := storage.GetQueueScheduleByOwner(ctx, client, int64(uid))
+	if err != nil {
+		_logger.Error().Err(err).Msg("cmdScheduleIslandQueue GetQueueScheduleByOwner")
+		return nil, Error{InnerError: err,
+			ReplyText: "预约队列时出错狸",
+		}
+	}
+	if schedule != nil {
+		return []tgbotapi.MessageConfig{{
+				BaseChat: tgbotapi.BaseChat{
+					ChatID:      message.Chat.ID,
+					ReplyMarkup: queueScheduleReplyMarkup(schedule),
+				},
+				Text: fmt.Sprintf("您已经预约了 %s 开放的队列，已有 %d 位客人登记。\n如需修改时间，请先取消预约", schedule.LocalStartTime().Format("01-02 15:04"), schedule.Len()),
+			}},
+			nil
+	}
+	owner := message.From.UserName
+	if len(owner) == 0 {
+		owner = message.From.FirstName
+	}
+	schedule = &storage.QueueSchedule{
+		Name:          island.Name,
+		OwnerID:       int64(uid),
+		Owner:         owner,
+		StartTime:     startTime,
+		Timezone:      island.Timezone,
+		MaxGuestCount: maxGuestCount,
+	}
+	if err = storage.CreateQueueSchedule(ctx, schedule); err != nil {
+		_logger.Error().Err(err).Msg("CreateQueueSchedule")
+		return nil, Error{InnerError: err,
+			ReplyText: "预约队列时出错狸",
+		}
+	}
+	return []tgbotapi.MessageConfig{{
+			BaseChat: tgbotapi.BaseChat{
+				ChatID:              message.Chat.ID,
+				ReplyToMessageID:    message.MessageID,
+				DisableNotification: true,
+				ReplyMarkup:         queueScheduleReplyMarkup(schedule),
+			},
+			Text: fmt.Sprintf("已预约 %s 开放前往 %s 的队列狸\n请使用分享按钮选择要分享的群/朋友，客人可以提前登记排队。\n到时间后我会提醒您输入开岛密码，登记的客人会按登记顺序加入队列。", schedule.LocalStartTime().Format("01-02 15:04"), schedule.Name),
+		}},
+		nil
+}
+
+func inlineQueryShareSchedule(query *tgbotapi.InlineQuery) (rst *tgbotapi.InlineConfig, err error) {
+	scheduleID := query.Query[15:]
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, _projectID)
+	if err != nil {
+		return
+	}
+	defer client.Close()
+	schedule, err := storage.GetQueueSchedule(ctx, client, scheduleID)
+	if err != nil {
+		return
+	}
+	if schedule.OwnerID != int64(query.From.ID) {
+		return nil, errors.New("not schedule owner")
+	}
+	island, _, err := storage.GetAnimalCrossingIslandByUserID(ctx, query.From.ID)
+	if err != nil {
+		return
+	}
+	var startTime = schedule.LocalStartTime().Format("01-02 15:04")
+	r := tgbotapi.NewInlineQueryResultArticle(query.ID, fmt.Sprintf("分享 %s 开放的 %s 的队列", startTime, schedule.Name), fmt.Sprintf("%s 将在 %s（%s）开放，现在可以提前登记排队\n本次信息：%s\n点击“提前登记”按钮后，请再点击“start”按钮\n登记链接：%s", schedule.Name, startTime, schedule.Timezone, island.Info, queuePreJoinURLPrefix+schedule.ID))
+	var joinBtn = tgbotapi.NewInlineKeyboardButtonURL("提前登记", queuePreJoinURLPrefix+schedule.ID)
+	var replyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(joinBtn))
+	r.ReplyMarkup = &replyMarkup
+	return &tgbotapi.InlineConfig{
+		InlineQueryID: query.ID,
+		Results:       []interface{}{r},
+		IsPersonal:    true,
+	}, nil
+}
+
+// cmdPreJoinQueue 客人提前登记预约的队列
+func cmdPreJoinQueue(message *tgbotapi.Message, scheduleID string) (replyMessage []tgbotapi.MessageConfig, err error) {
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, _projectID)
+	if err != nil {
+		_logger.Error().Err(err).Msg("create firestore client failed")
+		return nil, Error{InnerError: err,
+			ReplyText: "登记失败",
+		}
+	}
+	defer client.Close()
+	schedule, err := storage.GetQueueSchedule(ctx, client, scheduleID)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return []tgbotapi.MessageConfig{tgbotapi.NewMessage(message.Chat.ID, "预约已取消或者队列已经开放了狸")}, nil
+		}
+		_logger.Error().Err(err).Msg("query schedule failed")
+		return nil, Error{InnerError: err,
+			ReplyText: "登记失败",
+		}
+	}
+	uid := int64(message.From.ID)
+	if schedule.OwnerID == uid {
+		return []tgbotapi.MessageConfig{tgbotapi.NewMessage(message.Chat.ID, "自己不用排自己的队伍狸……")}, nil
+	}
+	if banned, err := storage.IsBannedByOwner(ctx, schedule.OwnerID, uid); err != nil {
+		_logger.Error().Err(err).Msg("query blacklist failed")
+	} else if banned {
+		return []tgbotapi.MessageConfig{tgbotapi.NewMessage(message.Chat.ID, "您无法加入这个岛主的队列")}, nil
+	}
+	username := message.From.UserName
+	if len(username) == 0 {
+		username = message.From.FirstName
+	}
+	if err = schedule.AddGuest(ctx, client, uid, username); err != nil {
+		if err.Error() == "already in this queue" {
+			return []tgbotapi.MessageConfig{tgbotapi.NewMessage(message.Chat.ID, "您已经登记过了狸")}, nil
+		}
+		_logger.Error().Err(err).Msg("pre-register guest failed")
+		return nil, Error{InnerError: err,
+			ReplyText: "登记失败",
+		}
+	}
+	var leaveBtn = tgbotapi.NewInlineKeyboardButtonData("取消登记："+schedule.Name, "/unprejoin_"+schedule.ID)
+	return []tgbotapi.MessageConfig{{
+			BaseChat: tgbotapi.BaseChat{
+				ChatID:      message.Chat.ID,
+				ReplyMarkup: tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(leaveBtn)),
+			},
+			Text: fmt.Sprintf("已登记前往 %s 的队列，登记顺序：%d\n队列将在 %s（%s）开放，届时会按登记顺序自动为您排队", schedule.Name, schedule.Len(), schedule.LocalStartTime().Format("01-02 15:04"), schedule.Timezone),
+		}},
+		nil
+}
+
+func callbackQueryLeaveSchedule(query *tgbotapi.CallbackQuery) (callbackConfig tgbotapi.CallbackConfig, err error) {
+	scheduleID := query.Data[11:]
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, _projectID)
+	if err != nil {
+		_logger.Error().Err(err).Msg("create firestore client failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	defer client.Close()
+	schedule, err := storage.GetQueueSchedule(ctx, client, scheduleID)
+	if err != nil {
+		var text = "failed"
+		if status.Code(err) == codes.NotFound {
+			text = "预约已取消或者队列已经开放了狸"
+		} else {
+			_logger.Error().Err(err).Msg("query schedule failed")
+		}
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            text,
+			ShowAlert:       false,
+		}, nil
+	}
+	if err = schedule.RemoveGuest(ctx, client, int64(query.From.ID)); err != nil && err.Error() != "not join in this queue" {
+		_logger.Error().Err(err).Msg("remove pre-registered guest failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	tgbot.Send(tgbotapi.NewEditMessageText(int64(query.From.ID), query.Message.MessageID, fmt.Sprintf("您已取消登记前往 %s 的队列", schedule.Name)))
+	return tgbotapi.CallbackConfig{
+		CallbackQueryID: query.ID,
+		Text:            "已取消登记",
+		ShowAlert:       false,
+	}, nil
+}
+
+func callbackQueryCancelSchedule(query *tgbotapi.CallbackQuery) (callbackConfig tgbotapi.CallbackConfig, err error) {
+	scheduleID := query.Data[16:]
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, _projectID)
+	if err != nil {
+		_logger.Error().Err(err).Msg("create firestore client failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	defer client.Close()
+	schedule, err := storage.GetQueueSchedule(ctx, client, scheduleID)
+	if err != nil {
+		var text = "failed"
+		if status.Code(err) == codes.NotFound {
+			text = "预约已取消"
+			tgbot.DeleteMessage(tgbotapi.NewDeleteMessage(int64(query.From.ID), query.Message.MessageID))
+		} else {
+			_logger.Error().Err(err).Msg("query schedule failed")
+		}
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            text,
+			ShowAlert:       false,
+		}, nil
+	}
+	if schedule.OwnerID != int64(query.From.ID) {
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "只有岛主才能操作狸",
+			ShowAlert:       false,
+		}, nil
+	}
+	if err = schedule.Delete(ctx, client); err != nil {
+		_logger.Error().Err(err).Msg("delete schedule failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	notifyScheduleGuests(schedule, fmt.Sprintf("岛主取消了 %s 开放的前往 %s 的队列", schedule.LocalStartTime().Format("01-02 15:04"), schedule.Name))
+	tgbot.Send(tgbotapi.NewEditMessageText(int64(query.From.ID), query.Message.MessageID, "预约已取消"))
+	return tgbotapi.CallbackConfig{
+		CallbackQueryID: query.ID,
+		Text:            "预约已取消",
+		ShowAlert:       false,
+	}, nil
+}
+
+// notifyScheduleGuests 通知所有登记的客人
+func notifyScheduleGuests(schedule *storage.QueueSchedule, text string) {
+	for _, uid := range schedule.GuestUIDs() {
+		if _, err := tgbot.Send(tgbotapi.NewMessage(uid, text)); err != nil {
+			_logger.Info().Err(err).Int64("uid", uid).Str("schedule", schedule.ID).Msg("notify schedule guest failed")
+		}
+	}
+}
+
+// checkQueueSchedules 到开放时间的预约，提醒岛主输入密码；超时未开放的预约自动取消
+func checkQueueSchedules(ctx context.Context, client *firestore.Client, now time.Time) {
+	schedules, err := storage.GetDueQueueSchedules(ctx, client, now)
+	if err != nil {
+		_logger.Error().Err(err).Msg("checkQueueSchedules GetDueQueueSchedules")
+		return
+	}
+	for _, schedule := range schedules {
+		if now.Sub(schedule.StartTime) > queueScheduleExpire {
+			if err = schedule.Delete(ctx, client); err != nil {
+				if status.Code(err) != codes.NotFound {
+					_logger.Error().Err(err).Str("schedule", schedule.ID).Msg("delete expired schedule failed")
+				}
+				continue
+			}
+			notifyScheduleGuests(schedule, fmt.Sprintf("前往 %s 的队列没有按预约时间开放，登记已取消狸", schedule.Name))
+			tgbot.Send(tgbotapi.NewMessage(schedule.OwnerID, fmt.Sprintf("您预约在 %s 开放的队列超过 %d 小时没有开放，已自动取消", schedule.LocalStartTime().Format("01-02 15:04"), int(queueScheduleExpire.Hours()))))
+			continue
+		}
+		if schedule.Notified {
+			continue
+		}
+		// 先标记再提醒，多个实例同时检查时只提醒一次
+		if err = schedule.MarkNotified(ctx, client); err != nil {
+			if err.Error() != "already notified" && status.Code(err) != codes.NotFound {
+				_logger.Error().Err(err).Str("schedule", schedule.ID).Msg("mark schedule notified failed")
+			}
+			continue
+		}
+		_, err = tgbot.Send(tgbotapi.MessageConfig{
+			BaseChat: tgbotapi.BaseChat{
+				ChatID:      schedule.OwnerID,
+				ReplyMarkup: tgbotapi.ForceReply{ForceReply: true, Selective: true},
+			},
+			Text: fmt.Sprintf("%s%s\n已有 %d 位客人提前登记，开放后会按登记顺序加入队列", queueSchedulePasswordPrompt, schedule.ID, schedule.Len()),
+		})
+		if err != nil {
+			_logger.Error().Err(err).Int64("uid", schedule.OwnerID).Msg("send schedule password prompt failed")
+			continue
+		}
+		notifyScheduleGuests(schedule, fmt.Sprintf("前往 %s 的队列到开放时间了，正在等待岛主输入密码狸", schedule.Name))
+	}
+}
+
+// cmdOpenScheduledQueue 岛主回复密码后，开启预约的队列，并把登记的客人按顺序加入队列
+func cmdOpenScheduledQueue(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig, err error) {
+	if !message.Chat.IsPrivate() {
+		return
+	}
+	promptArgs := strings.Fields(strings.TrimPrefix(message.ReplyToMessage.Text, queueSchedulePasswordPrompt))
+	if len(promptArgs) == 0 {
+		return nil, Error{ReplyText: "无法识别预约编号狸"}
+	}
+	scheduleID := promptArgs[0]
+	password, notice, err := parseDodoCode(message.Text)
+	if err != nil {
+		return []tgbotapi.MessageConfig{
+			{
+				BaseChat: tgbotapi.BaseChat{
+					ChatID: message.Chat.ID,
+				},
+				Text: err.Error(),
+			},
+			{
+				BaseChat: tgbotapi.BaseChat{
+					ChatID:      message.Chat.ID,
+					ReplyMarkup: tgbotapi.ForceReply{ForceReply: true, Selective: true},
+				},
+				Text: message.ReplyToMessage.Text,
+			},
+		}, nil
+	}
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, _projectID)
+	if err != nil {
+		_logger.Error().Err(err).Msg("cmdOpenScheduledQueue newClient")
+		return nil, Error{InnerError: err,
+			ReplyText: "创建队列时出错狸",
+		}
+	}
+	defer client.Close()
+	schedule, err := storage.GetQueueSchedule(ctx, client, scheduleID)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, Error{InnerError: err,
+				ReplyText: "预约已取消狸",
+			}
+		}
+		return nil, Error{InnerError: err,
+			ReplyText: "查询预约时出错狸",
+		}
+	}
+	if schedule.OwnerID != int64(message.From.ID) {
+		return nil, Error{ReplyText: "只有岛主才能操作狸"}
+	}
+	island, _, err := storage.GetAnimalCrossingIslandByUserID(ctx, message.From.ID)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, Error{InnerError: err,
+				ReplyText: "没有找到您的岛屿信息狸，如未记录，请先使用/addisland 登记岛屿信息狸。",
+			}
+		}
+		return nil, Error{InnerError: err,
+			ReplyText: "查询记录时出错狸",
+		}
+	}
+	if len(island.OnBoardQueueID) != 0 {
+		queue, _ := island.GetOnboardQueue(ctx)
+		if queue != nil && !queue.Dismissed {
+			return nil, Error{InnerError: err,
+				ReplyText: "请先 /dismiss 解散您当前已发起的队列，再回复密码开启预约的队列",
+			}
+		}
+		if _, err = island.ClearOldOnboardQueue(ctx); err != nil {
+			return nil, Error{InnerError: err,
+				ReplyText: "清理旧队列时出错狸",
+			}
+		}
+	}
+	queue, err := schedule.Open(ctx, client, island, password)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, Error{InnerError: err,
+				ReplyText: "这个预约已经开放或取消了狸",
+			}
+		}
+		_logger.Error().Err(err).Msg("open scheduled queue failed")
+		return nil, Error{InnerError: err,
+			ReplyText: "创建队列时出错狸",
+		}
+	}
+	tgbot.DeleteMessage(tgbotapi.NewDeleteMessage(message.Chat.ID, message.ReplyToMessage.MessageID))
+
+	var joinTime = time.Now().Unix()
+	for i, g := range queue.Queue {
+		logQueueEvent(queue, storage.QueueEventJoin, g.UID, g.Name)
+		_, err = tgbot.Send(tgbotapi.MessageConfig{
+			BaseChat: tgbotapi.BaseChat{
+				ChatID:      g.UID,
+				ReplyMarkup: queueGuestReplyMarkup(queue, joinTime),
+			},
+			Text: fmt.Sprintf("前往 %s 的队列已开放，您已按登记顺序加入队列，当前位置：%d/%d%s", queue.Name, i+1, queue.Len(), estimatedWaitText(queue, i+1)),
+		})
+		if err != nil {
+			_logger.Info().Err(err).Int64("uid", g.UID).Msg("notify pre-registered guest failed")
+		}
+	}
+	if queue.IsAuto && queue.Len() > 0 {
+		if err = sendNotify(ctx, client, queue); err != nil {
+			_logger.Info().Err(err).Str("queue", queue.ID).Msg("invite pre-registered guests failed")
+		}
+	}
+	return []tgbotapi.MessageConfig{{
+			BaseChat: tgbotapi.BaseChat{
+				ChatID:              message.Chat.ID,
+				DisableNotification: true,
+				ReplyMarkup:         queueOwnerReplyMarkup(queue),
+			},
+			Text: fmt.Sprintf("预约的队列已开放，密码：%s\n提前登记的 %d 位客人已按顺序加入队列\n/dismiss 立即解散队列\n/myqueue 列出自己创建的队列", dodoCodeText(password, notice), len(schedule.Guests)),
+		}},
+		nil
+}
@@ -0,0 +1,206 @@
+package chatbot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/doylecnn/new-nsfc-bot/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const queueSettingsUsage = "/queueset 查看当前队列的设置\n/queueset timeout [分钟] 被邀请的客人需在此时间内确认“准备起飞！”，超时自动跳过并邀请下一位，0 为不限\n/queueset reliable on|off 仅限近 7 天没有超时未到记录的客人加入\n/queueset privacy owner|count|public 队列成员仅岛主可见/客人只能看到人数/所有人可见\n/queueset maxlen [人数] 队列最大长度，满员后新加入的客人进入候补名单，有空位时自动转入队列，0 为不限\n/queueset lottery [分钟] [名额] 开启抽签报名，报名截止后随机抽出名额内的客人按抽签顺序排队，没抽中的客人会收到通知，名额为 0 时所有人抽签排序"
+
+var queuePrivacyNames = map[string]string{
+	storage.QueuePrivacyOwner:  "仅岛主可见",
+	storage.QueuePrivacyCount:  "客人只能看到人数",
+	storage.QueuePrivacyPublic: "所有人可见",
+}
+
+// getOwnedQueue 获得岛主当前开启的队列
+func getOwnedQueue(ctx context.Context, uid int) (queue *storage.OnboardQueue, err error) {
+	island, _, err := storage.GetAnimalCrossingIslandByUserID(ctx, uid)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, Error{InnerError: err,
+				ReplyText: "您还没有登记您的岛屿，请用/addisland 添加您的岛屿信息",
+			}
+		}
+		return nil, Error{InnerError: err,
+			ReplyText: "查询岛屿时出错了。",
+		}
+	}
+	if len(island.OnBoardQueueID) == 0 {
+		return nil, Error{InnerError: err,
+			ReplyText: "您没有开启队列",
+		}
+	}
+	queue, err = island.GetOnboardQueue(ctx)
+	if err != nil || queue.Dismissed {
+		return nil, Error{InnerError: err,
+			ReplyText: "您没有开启队列",
+		}
+	}
+	return
+}
+
+// queueSettingsText 队列当前设置
+func queueSettingsText(queue *storage.OnboardQueue) string {
+	var timeout = "不限"
+	if queue.InviteTimeout > 0 {
+		timeout = fmt.Sprintf("%d 分钟", queue.InviteTimeout)
+	}
+	var reliable = "否"
+	if queue.OnlyReliableGuests {
+		reliable = "是"
+	}
+	var maxLength = "不限"
+	if queue.MaxQueueLength > 0 {
+		maxLength = fmt.Sprintf("%d 人，当前候补 %d 人", queue.MaxQueueLength, queue.WaitlistLen())
+	}
+	var lottery = "未开启"
+	if queue.IsLotteryPending() {
+		lottery = lotteryStatusText(queue, time.Now())
+	}
+	return fmt.Sprintf("队列：%s\n确认时限：%s\n仅限可靠客人：%s\n队列成员：%s\n队列上限：%s\n抽签：%s", queue.Name, timeout, reliable, queuePrivacyNames[queue.Privacy()], maxLength, lottery)
+}
+
+// cmdQueueSettings 岛主调整当前队列的设置
+func cmdQueueSettings(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig, err error) {
+	ctx := context.Background()
+	queue, err := getOwnedQueue(ctx, message.From.ID)
+	if err != nil {
+		return
+	}
+	args := strings.Fields(message.CommandArguments())
+	if len(args) == 0 {
+		return []tgbotapi.MessageConfig{{
+				BaseChat: tgbotapi.BaseChat{
+					ChatID:              message.Chat.ID,
+					ReplyToMessageID:    message.MessageID,
+					DisableNotification: true,
+				},
+				Text: queueSettingsText(queue) + "\n\n" + queueSettingsUsage,
+			}},
+			nil
+	}
+	client, err := firestore.NewClient(ctx, _projectID)
+	if err != nil {
+		_logger.Error().Err(err).Msg("cmdQueueSettings newClient")
+		return nil, Error{InnerError: err,
+			ReplyText: "更新队列设置时出错狸",
+		}
+	}
+	defer client.Close()
+	switch strings.ToLower(args[0]) {
+	case "timeout":
+		if len(args) != 2 {
+			return nil, Error{ReplyText: queueSettingsUsage}
+		}
+		timeout, err := strconv.Atoi(args[1])
+		if err != nil || timeout < 0 || timeout > 60 {
+			return nil, Error{InnerError: err,
+				ReplyText: "确认时限必须是数字，取值范围 [0，60]",
+			}
+		}
+		if err = queue.UpdateSetting(ctx, client, "InviteTimeout", timeout); err != nil {
+			_logger.Error().Err(err).Msg("update InviteTimeout failed")
+			return nil, Error{InnerError: err,
+				ReplyText: "更新队列设置时出错狸",
+			}
+		}
+		queue.InviteTimeout = timeout
+	case "reliable":
+		if len(args) != 2 || (args[1] != "on" && args[1] != "off") {
+			return nil, Error{ReplyText: queueSettingsUsage}
+		}
+		reliable := args[1] == "on"
+		if err = queue.UpdateSetting(ctx, client, "OnlyReliableGuests", reliable); err != nil {
+			_logger.Error().Err(err).Msg("update OnlyReliableGuests failed")
+			return nil, Error{InnerError: err,
+				ReplyText: "更新队列设置时出错狸",
+			}
+		}
+		queue.OnlyReliableGuests = reliable
+	case "privacy":
+		if len(args) != 2 {
+			return nil, Error{ReplyText: queueSettingsUsage}
+		}
+		privacy := strings.ToLower(args[1])
+		if _, ok := queuePrivacyNames[privacy]; !ok {
+			return nil, Error{ReplyText: queueSettingsUsage}
+		}
+		if err = queue.UpdateSetting(ctx, client, "MemberPrivacy", privacy); err != nil {
+			_logger.Error().Err(err).Msg("update MemberPrivacy failed")
+			return nil, Error{InnerError: err,
+				ReplyText: "更新队列设置时出错狸",
+			}
+		}
+		queue.MemberPrivacy = privacy
+	case "maxlen":
+		if len(args) != 2 {
+			return nil, Error{ReplyText: queueSettingsUsage}
+		}
+		maxLength, err := strconv.Atoi(args[1])
+		if err != nil || maxLength < 0 || maxLength > 200 {
+			return nil, Error{InnerError: err,
+				ReplyText: "队列上限必须是数字，取值范围 [0，200]",
+			}
+		}
+		if err = queue.UpdateSetting(ctx, client, "MaxQueueLength", maxLength); err != nil {
+			_logger.Error().Err(err).Msg("update MaxQueueLength failed")
+			return nil, Error{InnerError: err,
+				ReplyText: "更新队列设置时出错狸",
+			}
+		}
+		queue.MaxQueueLength = maxLength
+		// 上限调高或取消后，候补的客人可以转入队列了
+		promoteWaitlist(ctx, client, queue)
+	case "lottery":
+		if len(args) != 3 {
+			return nil, Error{ReplyText: queueSettingsUsage}
+		}
+		minutes, err := strconv.Atoi(args[1])
+		if err != nil || minutes < 1 || minutes > 120 {
+			return nil, Error{InnerError: err,
+				ReplyText: "报名时长必须是数字，取值范围 [1，120]",
+			}
+		}
+		slots, err := strconv.Atoi(args[2])
+		if err != nil || slots < 0 || slots > 200 {
+			return nil, Error{InnerError: err,
+				ReplyText: "抽签名额必须是数字，取值范围 [0，200]",
+			}
+		}
+		if err = queue.StartLottery(ctx, client, time.Now().Add(time.Duration(minutes)*time.Minute), slots); err != nil {
+			if err.Error() == "lottery already started" {
+				return nil, Error{InnerError: err,
+					ReplyText: "抽签已经在报名中了狸",
+				}
+			}
+			_logger.Error().Err(err).Msg("start lottery failed")
+			return nil, Error{InnerError: err,
+				ReplyText: "更新队列设置时出错狸",
+			}
+		}
+		notifyQueueGuests(queue, fmt.Sprintf("前往 %s 的队列改为抽签排队狸\n抽签：%s\n报名截止后按抽签结果重新排列队列，并通知每一位客人", queue.Name, lotteryStatusText(queue, time.Now())))
+	default:
+		return nil, Error{ReplyText: queueSettingsUsage}
+	}
+	return []tgbotapi.MessageConfig{{
+			BaseChat: tgbotapi.BaseChat{
+				ChatID:              message.Chat.ID,
+				ReplyToMessageID:    message.MessageID,
+				DisableNotification: true,
+				ReplyMarkup:         queueOwnerReplyMarkup(queue),
+			},
+			Text: "已更新队列设置狸\n" + queueSettingsText(queue),
+		}},
+		nil
+}
@@ -0,0 +1,75 @@
+package chatbot
+
+import (
+	"context"
+	"strings"
+	"unicode/utf16"
+
+	"cloud.google.com/go/firestore"
+	"github.com/doylecnn/new-nsfc-bot/storage"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// queueJoinURLPrefix 加入队列的链接前缀，后接队列 ID
+const queueJoinURLPrefix = "https://t.me/NS_FC_bot?start=join_"
+
+// recordQueueShare 岛主在群里发出带有加入链接的分享消息时，记录队列被分享到了这个群
+func recordQueueShare(message *tgbotapi.Message) {
+	if message.Chat.IsPrivate() || message.Entities == nil {
+		return
+	}
+	var queueIDs []string
+	// entity 的 Offset 和 Length 以 UTF-16 计
+	text := utf16.Encode([]rune(message.Text))
+	for _, entity := range *message.Entities {
+		var link string
+		if entity.Type == "url" && entity.Offset >= 0 && entity.Offset+entity.Length <= len(text) {
+			link = string(utf16.Decode(text[entity.Offset : entity.Offset+entity.Length]))
+		} else if entity.Type == "text_link" {
+			link = entity.URL
+		} else {
+			continue
+		}
+		if strings.HasPrefix(link, queueJoinURLPrefix) {
+			queueIDs = append(queueIDs, link[len(queueJoinURLPrefix):])
+		}
+	}
+	if len(queueIDs) == 0 {
+		return
+	}
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, _projectID)
+	if err != nil {
+		_logger.Error().Err(err).Msg("recordQueueShare create firestore client failed")
+		return
+	}
+	defer client.Close()
+	for _, queueID := range queueIDs {
+		queue, err := storage.GetOnboardQueue(ctx, client, queueID)
+		if err != nil || queue.Dismissed || !queue.IsHost(int64(message.From.ID)) {
+			continue
+		}
+		if err = queue.AddSharedChat(ctx, client, message.Chat.ID); err != nil {
+			_logger.Error().Err(err).Str("queue", queueID).Int64("gid", message.Chat.ID).Msg("record queue share failed")
+		}
+	}
+}
+
+// canJoinQueue 队列被分享到群后，只有这些群的成员可以加入
+func canJoinQueue(queue *storage.OnboardQueue, uid int64) bool {
+	if len(queue.SharedChatIDs) == 0 {
+		return true
+	}
+	for _, chatID := range queue.SharedChatIDs {
+		member, err := tgbot.GetChatMember(tgbotapi.ChatConfigWithUser{ChatID: chatID, UserID: int(uid)})
+		if err != nil {
+			_logger.Info().Err(err).Int64("gid", chatID).Int64("uid", uid).Msg("canJoinQueue GetChatMember")
+			continue
+		}
+		if !member.HasLeft() && !member.WasKicked() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package chatbot
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/doylecnn/new-nsfc-bot/storage"
+)
+
+// queueSlots 同时登岛的客人数，手动队列视为 1
+func queueSlots(queue *storage.OnboardQueue) int {
+	if queue.IsAuto && queue.MaxGuestCount > 0 {
+		return queue.MaxGuestCount
+	}
+	return 1
+}
+
+// estimatedWait 根据平均登岛时长，估算排在 position 的客人还需等待多久
+func estimatedWait(queue *storage.OnboardQueue, position int) (wait time.Duration, ok bool) {
+	avg, ok := queue.AverageVisitDuration()
+	if !ok || position <= 0 {
+		return 0, false
+	}
+	slots := queueSlots(queue)
+	// 岛上还有空位时，前面的客人会直接被邀请
+	ahead := position - 1 - (slots - queue.LandedLen())
+	if ahead < 0 {
+		return 0, true
+	}
+	rounds := ahead/slots + 1
+	return time.Duration(rounds) * avg, true
+}
+
+// estimatedWaitText 预计等待时间的说明，没有登岛记录时为空
+func estimatedWaitText(queue *storage.OnboardQueue, position int) string {
+	if queue.Paused {
+		return "\n岛主暂停了邀请，恢复后会按顺序继续邀请"
+	}
+	if queue.IsLotteryPending() {
+		return "\n当前为抽签排队，" + lotteryStatusText(queue, time.Now()) + "\n抽签后会通知您结果"
+	}
+	wait, ok := estimatedWait(queue, position)
+	if !ok {
+		return ""
+	}
+	if wait < time.Minute {
+		return "\n预计马上就会轮到您"
+	}
+	return fmt.Sprintf("\n预计还需等待约 %d 分钟", int(math.Ceil(wait.Minutes())))
+}
+
+// queueThroughputText 队列的登岛统计，供岛主查看
+func queueThroughputText(queue *storage.OnboardQueue) string {
+	avg, ok := queue.AverageVisitDuration()
+	if !ok {
+		return fmt.Sprintf("已完成登岛：%d 位", queue.VisitCount)
+	}
+	perHour := float64(queueSlots(queue)) * float64(time.Hour) / float64(avg)
+	return fmt.Sprintf("已完成登岛：%d 位，最近平均每位 %.1f 分钟，约每小时 %.1f 位", queue.VisitCount, avg.Minutes(), perHour)
+}
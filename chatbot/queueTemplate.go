@@ -0,0 +1,286 @@
+package chatbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"cloud.google.com/go/firestore"
+	"github.com/doylecnn/new-nsfc-bot/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	// queueTemplatePasswordPrompt 使用模板开启队列时，要求岛主回复密码的提示，后接模板名
+	queueTemplatePasswordPrompt = "请输入开岛密码，将以此模板开启队列："
+	// maxQueueTemplateNameLength 模板名的最大长度，受限于按钮回调数据的长度
+	maxQueueTemplateNameLength = 16
+	queueTemplateUsage         = "/queuetemplate save [模板名] [给客人的说明] 把当前队列的设置和本次开岛信息保存为模板\n/queuetemplate list 列出已保存的模板\n/queuetemplate use [模板名] 使用模板开启队列，只需输入密码\n/queuetemplate delete [模板名] 删除模板"
+)
+
+// queueIntroText 客人加入队列时显示的岛主说明
+func queueIntroText(queue *storage.OnboardQueue) string {
+	if len(queue.Intro) == 0 {
+		return ""
+	}
+	return "\n岛主说明：" + queue.Intro
+}
+
+// queueTemplateText 模板内容
+func queueTemplateText(t storage.QueueTemplate) string {
+	var mode = "岛主手动控制队列"
+	if t.MaxGuestCount > 0 {
+		mode = fmt.Sprintf("自动队列，同时登岛客人数：%d", t.MaxGuestCount)
+	}
+	var text = fmt.Sprintf("模板：%s\n%s\n本次开岛信息：%s", t.Name, mode, t.Info)
+	if len(t.Intro) > 0 {
+		text += "\n给客人的说明：" + t.Intro
+	}
+	return text
+}
+
+// cmdQueueTemplate 管理队列模板
+func cmdQueueTemplate(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig, err error) {
+	args := strings.Fields(message.CommandArguments())
+	if len(args) == 0 {
+		return nil, Error{ReplyText: queueTemplateUsage}
+	}
+	var replyText string
+	var replyMarkup interface{}
+	switch {
+	case args[0] == "save" && len(args) >= 2:
+		replyText, err = saveQueueTemplate(message, args[1], strings.Join(args[2:], " "))
+	case args[0] == "list" && len(args) == 1:
+		replyText, replyMarkup, err = listQueueTemplates(message.From.ID)
+	case args[0] == "use" && len(args) == 2:
+		ctx := context.Background()
+		if _, err = storage.GetQueueTemplate(ctx, message.From.ID, args[1]); err != nil {
+			if status.Code(err) == codes.NotFound {
+				return nil, Error{InnerError: err,
+					ReplyText: "没有找到这个模板狸，/queuetemplate list 查看已保存的模板",
+				}
+			}
+			return nil, Error{InnerError: err,
+				ReplyText: "查询模板时出错狸",
+			}
+		}
+		return []tgbotapi.MessageConfig{queueTemplatePasswordMessage(message.Chat.ID, args[1])}, nil
+	case args[0] == "delete" && len(args) == 2:
+		if err = storage.DeleteQueueTemplate(context.Background(), message.From.ID, args[1]); err != nil {
+			return nil, Error{InnerError: err,
+				ReplyText: "删除模板时出错狸",
+			}
+		}
+		replyText = fmt.Sprintf("已删除模板：%s", args[1])
+	default:
+		return nil, Error{ReplyText: queueTemplateUsage}
+	}
+	if err != nil {
+		return
+	}
+	return []tgbotapi.MessageConfig{{
+			BaseChat: tgbotapi.BaseChat{
+				ChatID:              message.Chat.ID,
+				ReplyToMessageID:    message.MessageID,
+				DisableNotification: true,
+				ReplyMarkup:         replyMarkup,
+			},
+			Text: replyText,
+		}},
+		nil
+}
+
+// saveQueueTemplate 把当前队列的设置保存为模板
+func saveQueueTemplate(message *tgbotapi.Message, name, intro string) (replyText string, err error) {
+	if strings.Contains(name, "/") || utf8.RuneCountInString(name) > maxQueueTemplateNameLength {
+		return "", Error{ReplyText: fmt.Sprintf("模板名不能包含“/”，且最多 %d 个字", maxQueueTemplateNameLength)}
+	}
+	ctx := context.Background()
+	queue, err := getOwnedQueue(ctx, message.From.ID)
+	if err != nil {
+		if e, ok := err.(Error); ok {
+			e.ReplyText += "，请在开启队列后保存模板狸"
+			return "", e
+		}
+		return
+	}
+	island, _, err := storage.GetAnimalCrossingIslandByUserID(ctx, message.From.ID)
+	if err != nil {
+		return "", Error{InnerError: err,
+			ReplyText: "查询岛屿时出错了。",
+		}
+	}
+	if len(intro) == 0 {
+		intro = queue.Intro
+	}
+	var t = storage.QueueTemplate{
+		Name:               name,
+		Info:               island.Info,
+		Intro:              intro,
+		MaxGuestCount:      queue.MaxGuestCount,
+		InviteTimeout:      queue.InviteTimeout,
+		OnlyReliableGuests: queue.OnlyReliableGuests,
+		MemberPrivacy:      queue.MemberPrivacy,
+		MaxQueueLength:     queue.MaxQueueLength,
+	}
+	if err = storage.SaveQueueTemplate(ctx, message.From.ID, t); err != nil {
+		return "", Error{InnerError: err,
+			ReplyText: "保存模板时出错狸",
+		}
+	}
+	return fmt.Sprintf("已保存队列模板狸\n%s\n下次使用 /queuetemplate use %s 一步开启队列", queueTemplateText(t), t.Name), nil
+}
+
+// listQueueTemplates 列出已保存的模板，每个模板一个开启按钮
+func listQueueTemplates(uid int) (replyText string, replyMarkup interface{}, err error) {
+	templates, err := storage.GetQueueTemplates(context.Background(), uid)
+	if err != nil {
+		return "", nil, Error{InnerError: err,
+			ReplyText: "查询模板时出错狸",
+		}
+	}
+	if len(templates) == 0 {
+		return "您还没有保存队列模板狸\n" + queueTemplateUsage, nil, nil
+	}
+	var texts []string
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, t := range templates {
+		texts = append(texts, queueTemplateText(t))
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("使用模板："+t.Name, "/usetemplate_"+t.Name)))
+	}
+	return strings.Join(texts, "\n\n"), tgbotapi.NewInlineKeyboardMarkup(rows...), nil
+}
+
+// queueTemplatePasswordMessage 要求岛主回复密码的消息
+func queueTemplatePasswordMessage(chatID int64, name string) tgbotapi.MessageConfig {
+	return tgbotapi.MessageConfig{
+		BaseChat: tgbotapi.BaseChat{
+			ChatID:      chatID,
+			ReplyMarkup: tgbotapi.ForceReply{ForceReply: true, Selective: true},
+		},
+		Text: queueTemplatePasswordPrompt + name,
+	}
+}
+
+func callbackQueryUseQueueTemplate(query *tgbotapi.CallbackQuery) (callbackConfig tgbotapi.CallbackConfig, err error) {
+	name := query.Data[13:]
+	if _, err = tgbot.Send(queueTemplatePasswordMessage(int64(query.From.ID), name)); err != nil {
+		_logger.Error().Err(err).Int("uid", query.From.ID).Msg("send queue template password prompt failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	err = errors.New("no_alert")
+	return
+}
+
+// cmdOpenQueueFromTemplate 岛主回复密码后，按模板开启队列
+func cmdOpenQueueFromTemplate(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig, err error) {
+	if !message.Chat.IsPrivate() {
+		return
+	}
+	name := strings.TrimPrefix(message.ReplyToMessage.Text, queueTemplatePasswordPrompt)
+	password, notice, err := parseDodoCode(message.Text)
+	if err != nil {
+		return []tgbotapi.MessageConfig{
+			{
+				BaseChat: tgbotapi.BaseChat{
+					ChatID: message.Chat.ID,
+				},
+				Text: err.Error(),
+			},
+			queueTemplatePasswordMessage(message.Chat.ID, name),
+		}, nil
+	}
+	ctx := context.Background()
+	t, err := storage.GetQueueTemplate(ctx, message.From.ID, name)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, Error{InnerError: err,
+				ReplyText: "模板已删除狸",
+			}
+		}
+		return nil, Error{InnerError: err,
+			ReplyText: "查询模板时出错狸",
+		}
+	}
+	island, residentUID, err := storage.GetAnimalCrossingIslandByUserID(ctx, message.From.ID)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, Error{InnerError: err,
+				ReplyText: "没有找到您的岛屿信息狸，如未记录，请先使用/addisland 登记岛屿信息狸。",
+			}
+		}
+		return nil, Error{InnerError: err,
+			ReplyText: "查询记录时出错狸",
+		}
+	}
+	if len(island.OnBoardQueueID) != 0 {
+		queue, _ := island.GetOnboardQueue(ctx)
+		if queue != nil && !queue.Dismissed {
+			return nil, Error{InnerError: err,
+				ReplyText: "请先 /dismiss 解散您当前已发起的队列",
+			}
+		}
+		if _, err = island.ClearOldOnboardQueue(ctx); err != nil {
+			return nil, Error{InnerError: err,
+				ReplyText: "清理旧队列时出错狸",
+			}
+		}
+	}
+	uid := message.From.ID
+	if residentUID > 0 {
+		uid = residentUID
+	}
+	owner := message.From.UserName
+	if len(owner) == 0 {
+		owner = message.From.FirstName
+	}
+	island.AirportIsOpen = true
+	island.OpenTime = time.Now()
+	island.Info = t.Info
+	if err = island.Update(ctx); err != nil {
+		return nil, Error{InnerError: err,
+			ReplyText: "更新岛屿开放信息时出错狸",
+		}
+	}
+	queue, err := island.CreateOnboardQueue(ctx, int64(uid), owner, password, t.MaxGuestCount)
+	if err != nil {
+		_logger.Error().Err(err).Msg("创建模板队列时出错")
+		return nil, Error{InnerError: err,
+			ReplyText: "创建队列时出错狸",
+		}
+	}
+	client, err := firestore.NewClient(ctx, _projectID)
+	if err != nil {
+		return nil, Error{InnerError: err,
+			ReplyText: "队列已创建，但应用模板设置时出错狸，请用 /queueset 调整",
+		}
+	}
+	defer client.Close()
+	if err = queue.ApplyTemplate(ctx, client, *t); err != nil {
+		_logger.Error().Err(err).Str("queue", queue.ID).Msg("apply queue template failed")
+		return nil, Error{InnerError: err,
+			ReplyText: "队列已创建，但应用模板设置时出错狸，请用 /queueset 调整",
+		}
+	}
+	tgbot.DeleteMessage(tgbotapi.NewDeleteMessage(message.Chat.ID, message.ReplyToMessage.MessageID))
+
+	return []tgbotapi.MessageConfig{{
+			BaseChat: tgbotapi.BaseChat{
+				ChatID:              message.Chat.ID,
+				DisableNotification: true,
+				ReplyMarkup:         queueOwnerReplyMarkup(queue),
+			},
+			Text: fmt.Sprintf("已按模板 %s 创建队列，密码：%s\n%s\n请使用分享按钮选择要分享排队的群/朋友\n/dismiss 立即解散队列\n/myqueue 列出自己创建的队列", t.Name, dodoCodeText(password, notice), queueSettingsText(queue)),
+		}},
+		nil
+}
@@ -0,0 +1,235 @@
+package chatbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/doylecnn/new-nsfc-bot/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// guestPurposes 客人可以选择的登岛目的，按显示顺序
+var guestPurposes = []string{storage.GuestPurposeTurnip, storage.GuestPurposeKicks, storage.GuestPurposeVisitor, storage.GuestPurposeOther}
+
+var guestPurposeNames = map[string]string{
+	storage.GuestPurposeTurnip:  "卖大头菜",
+	storage.GuestPurposeKicks:   "买曹卖的商品",
+	storage.GuestPurposeVisitor: "找特殊访客",
+	storage.GuestPurposeOther:   "其他",
+}
+
+// guestVisitText 岛主查看队列时，客人名字后显示的登岛目的和剩余次数
+func guestVisitText(queue *storage.OnboardQueue, uid int64) string {
+	var parts []string
+	if name, ok := guestPurposeNames[queue.GuestPurpose(uid)]; ok {
+		parts = append(parts, name)
+	}
+	if trips := queue.GuestTrips(uid); trips > 1 {
+		parts = append(parts, fmt.Sprintf("还剩 %d 趟", trips))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "（" + strings.Join(parts, "，") + "）"
+}
+
+// sendGuestVisitPrompt 客人加入队列后，请客人选择登岛目的和次数
+func sendGuestVisitPrompt(queue *storage.OnboardQueue, uid int64) {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var row []tgbotapi.InlineKeyboardButton
+	for _, purpose := range guestPurposes {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(guestPurposeNames[purpose], fmt.Sprintf("/purpose_%s_%s", purpose, queue.ID)))
+		if len(row) == 2 {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	_, err := tgbot.Send(tgbotapi.MessageConfig{
+		BaseChat: tgbotapi.BaseChat{
+			ChatID:      uid,
+			ReplyMarkup: tgbotapi.NewInlineKeyboardMarkup(rows...),
+		},
+		Text: fmt.Sprintf("请选择这次前往 %s 的目的，方便岛主安排狸（可不选）", queue.Name),
+	})
+	if err != nil {
+		_logger.Info().Err(err).Int64("uid", uid).Msg("send guest visit prompt failed")
+	}
+}
+
+// callbackQueryGuestPurpose 客人选择登岛目的后，继续选择登岛次数
+func callbackQueryGuestPurpose(query *tgbotapi.CallbackQuery) (callbackConfig tgbotapi.CallbackConfig, err error) {
+	args := strings.SplitN(query.Data[9:], "_", 2)
+	if len(args) != 2 {
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	purpose, queueID := args[0], args[1]
+	if _, ok := guestPurposeNames[purpose]; !ok {
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var row []tgbotapi.InlineKeyboardButton
+	for trips := 1; trips <= storage.MaxGuestTrips; trips++ {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%d 趟", trips), fmt.Sprintf("/trips_%s_%d_%s", purpose, trips, queueID)))
+	}
+	rows = append(rows, row)
+	var replyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	tgbot.Send(tgbotapi.EditMessageTextConfig{
+		BaseEdit: tgbotapi.BaseEdit{
+			ChatID:      query.Message.Chat.ID,
+			MessageID:   query.Message.MessageID,
+			ReplyMarkup: &replyMarkup,
+		},
+		Text: fmt.Sprintf("目的：%s\n需要登岛几趟？多趟的话每次“我要回家啦！”后会自动回到队尾排下一趟狸", guestPurposeNames[purpose]),
+	})
+	return tgbotapi.CallbackConfig{
+		CallbackQueryID: query.ID,
+		Text:            guestPurposeNames[purpose],
+		ShowAlert:       false,
+	}, nil
+}
+
+// callbackQueryGuestTrips 记录客人选择的登岛目的和次数
+func callbackQueryGuestTrips(query *tgbotapi.CallbackQuery) (callbackConfig tgbotapi.CallbackConfig, err error) {
+	args := strings.SplitN(query.Data[7:], "_", 3)
+	if len(args) != 3 {
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	purpose, queueID := args[0], args[2]
+	trips, err := strconv.Atoi(args[1])
+	if _, ok := guestPurposeNames[purpose]; !ok || err != nil || trips < 1 || trips > storage.MaxGuestTrips {
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	uid := int64(query.From.ID)
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, _projectID)
+	if err != nil {
+		_logger.Error().Err(err).Msg("create firestore client failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	defer client.Close()
+	queue, err := storage.GetOnboardQueue(ctx, client, queueID)
+	if err != nil {
+		var text = "failed"
+		if status.Code(err) == codes.NotFound {
+			text = "队列已取消"
+		} else {
+			_logger.Error().Err(err).Msg("query queue failed")
+		}
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            text,
+			ShowAlert:       false,
+		}, nil
+	}
+	_, inQueue := queue.GetGuestName(uid)
+	if _, waitErr := queue.GetWaitlistPosition(uid); !inQueue && waitErr != nil {
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "您已不在这个队列中",
+			ShowAlert:       false,
+		}, nil
+	}
+	if err = queue.SetGuestVisit(ctx, client, uid, purpose, trips); err != nil {
+		_logger.Error().Err(err).Msg("set guest visit failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	tgbot.Send(tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID,
+		fmt.Sprintf("前往 %s：%s，共 %d 趟", queue.Name, guestPurposeNames[purpose], trips)))
+	return tgbotapi.CallbackConfig{
+		CallbackQueryID: query.ID,
+		Text:            "已告诉岛主狸",
+		ShowAlert:       false,
+	}, nil
+}
+
+// callbackQueryNextTrip 多趟登岛的客人完成一趟后，自动回到队尾排下一趟
+func callbackQueryNextTrip(ctx context.Context, client *firestore.Client, query *tgbotapi.CallbackQuery, queue *storage.OnboardQueue, name string) (callbackConfig tgbotapi.CallbackConfig, err error) {
+	uid := int64(query.From.ID)
+	waitlisted, err := queue.NextTrip(ctx, client, uid)
+	if err != nil {
+		_logger.Error().Err(err).Msg("requeue for next trip failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	recordGuestEvent(uid, storage.GuestEventDone)
+	logQueueEvent(queue, storage.QueueEventDone, uid, name)
+	if !waitlisted {
+		logQueueEvent(queue, storage.QueueEventJoin, uid, name)
+	}
+
+	if queue.IsAuto && queue.MaxGuestCount > 0 && queue.LandedLen() < queue.MaxGuestCount {
+		sendNotify(ctx, client, queue)
+	}
+
+	trips := queue.GuestTrips(uid)
+	if waitlisted {
+		var replyMarkup = queueWaitlistReplyMarkup(queue)
+		_, err = tgbot.Send(tgbotapi.EditMessageTextConfig{
+			BaseEdit: tgbotapi.BaseEdit{
+				ChatID:      query.Message.Chat.ID,
+				MessageID:   query.Message.MessageID,
+				ReplyMarkup: &replyMarkup,
+			},
+			Text: fmt.Sprintf("这一趟辛苦啦！还剩 %d 趟。\n%s", trips, waitlistJoinedText(queue, uid)),
+		})
+		if err != nil {
+			_logger.Error().Err(err).Int64("uid", uid).Msg("notify next trip failed")
+		}
+		notifyHosts(queue, fmt.Sprintf("@%s 完成了一趟，队列已满，已进入候补名单，还剩 %d 趟\n队列剩余：%d\n候补：%d\n当前在岛：%d", name, trips, queue.Len(), queue.WaitlistLen(), queue.LandedLen()))
+		err = errors.New("no_alert")
+		return
+	}
+	if position, err := queue.GetPosition(uid); err == nil {
+		var replyMarkup = queueGuestReplyMarkup(queue, time.Now().Unix())
+		_, err = tgbot.Send(tgbotapi.EditMessageTextConfig{
+			BaseEdit: tgbotapi.BaseEdit{
+				ChatID:      query.Message.Chat.ID,
+				MessageID:   query.Message.MessageID,
+				ReplyMarkup: &replyMarkup,
+			},
+			Text: fmt.Sprintf("这一趟辛苦啦！已自动回到前往 %s 的队尾排下一趟，还剩 %d 趟，当前位置：%d/%d%s", queue.Name, trips, position, queue.Len(), estimatedWaitText(queue, position)),
+		})
+		if err != nil {
+			_logger.Error().Err(err).Int64("uid", uid).Msg("notify next trip failed")
+		}
+	}
+
+	notifyHosts(queue, fmt.Sprintf("@%s 完成了一趟，已自动回到队尾，还剩 %d 趟\n队列剩余：%d\n当前在岛：%d", name, trips, queue.Len(), queue.LandedLen()))
+	err = errors.New("no_alert")
+	return
+}
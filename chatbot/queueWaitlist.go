@@ -0,0 +1,105 @@
+package chatbot
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/doylecnn/new-nsfc-bot/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// queueWaitlistReplyMarkup 候补中客人的操作按钮
+func queueWaitlistReplyMarkup(queue *storage.OnboardQueue) tgbotapi.InlineKeyboardMarkup {
+	var leaveBtn = tgbotapi.NewInlineKeyboardButtonData("离开候补："+queue.Name, "/leavewait_"+queue.ID)
+	return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(leaveBtn))
+}
+
+// waitlistJoinedText 加入候补后的提示
+func waitlistJoinedText(queue *storage.OnboardQueue, uid int64) string {
+	position, _ := queue.GetWaitlistPosition(uid)
+	return fmt.Sprintf("前往 %s 的队列已满（上限 %d 人），您已进入候补名单，候补位置：%d/%d。\n队列有空位时会自动为您排队狸", queue.Name, queue.MaxQueueLength, position, queue.WaitlistLen())
+}
+
+// queueFullText 分享卡片上显示的满员状态
+func queueFullText(queue *storage.OnboardQueue) string {
+	return fmt.Sprintf("队列已满（%d 人候补）", queue.WaitlistLen())
+}
+
+// promoteWaitlist 队列有空位时，把候补的客人按顺序转入队列并通知他们
+func promoteWaitlist(ctx context.Context, client *firestore.Client, queue *storage.OnboardQueue) {
+	promoted, err := queue.PromoteWaitlist(ctx, client)
+	if err != nil {
+		_logger.Error().Err(err).Str("queue", queue.ID).Msg("promote waitlist failed")
+		return
+	}
+	var joinTime = time.Now().Unix()
+	for _, g := range promoted {
+		logQueueEvent(queue, storage.QueueEventJoin, g.UID, g.Name)
+		position, _ := queue.GetPosition(g.UID)
+		_, err = tgbot.Send(tgbotapi.MessageConfig{
+			BaseChat: tgbotapi.BaseChat{
+				ChatID:      g.UID,
+				ReplyMarkup: queueGuestReplyMarkup(queue, joinTime),
+			},
+			Text: fmt.Sprintf("前往 %s 的队列有空位了，您已从候补转入队列，当前位置：%d/%d%s", queue.Name, position, queue.Len(), estimatedWaitText(queue, position)),
+		})
+		if err != nil {
+			_logger.Info().Err(err).Int64("uid", g.UID).Msg("notify promoted guest failed")
+		}
+	}
+}
+
+func callbackQueryLeaveWaitlist(query *tgbotapi.CallbackQuery) (callbackConfig tgbotapi.CallbackConfig, err error) {
+	queueID := query.Data[11:]
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, _projectID)
+	if err != nil {
+		_logger.Error().Err(err).Msg("create firestore client failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	defer client.Close()
+	queue, err := storage.GetOnboardQueue(ctx, client, queueID)
+	if err != nil {
+		var text = "failed"
+		if status.Code(err) == codes.NotFound {
+			text = "队列已取消"
+		} else {
+			_logger.Error().Err(err).Msg("query queue failed")
+		}
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            text,
+			ShowAlert:       false,
+		}, nil
+	}
+	if err = queue.LeaveWaitlist(ctx, client, int64(query.From.ID)); err != nil {
+		if err.Error() == "not in waitlist" {
+			return tgbotapi.CallbackConfig{
+				CallbackQueryID: query.ID,
+				Text:            "您已不在候补名单中",
+				ShowAlert:       false,
+			}, nil
+		}
+		_logger.Error().Err(err).Msg("leave waitlist failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	tgbot.Send(tgbotapi.NewEditMessageText(int64(query.From.ID), query.Message.MessageID, fmt.Sprintf("您已离开前往 %s 的候补名单", queue.Name)))
+	return tgbotapi.CallbackConfig{
+		CallbackQueryID: query.ID,
+		Text:            "已离开候补",
+		ShowAlert:       false,
+	}, nil
+}
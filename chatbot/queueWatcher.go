@@ -0,0 +1,141 @@
+package chatbot
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/doylecnn/new-nsfc-bot/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// CheckQueues 检查所有队列和预约，处理超时未确认的客人
+// 由 App Engine cron 每分钟调用，多个实例同时检查时，每项处理都在事务中再次确认，只会执行一次
+func (c ChatBot) CheckQueues() {
+	checkQueues()
+}
+
+func checkQueues() {
+	ctx := context.Background()
+	client, err := firestore.NewClient(ctx, _projectID)
+	if err != nil {
+		_logger.Error().Err(err).Msg("checkQueues create firestore client failed")
+		return
+	}
+	defer client.Close()
+	queues, err := storage.GetAllOnboardQueues(ctx, client)
+	if err != nil {
+		_logger.Error().Err(err).Msg("checkQueues GetAllOnboardQueues")
+		return
+	}
+	now := time.Now()
+	checkQueueSchedules(ctx, client, now)
+	for _, queue := range queues {
+		drawQueueLottery(ctx, client, queue, now)
+		skipExpiredInvitees(ctx, client, queue, now)
+		requeueCrashedGuests(ctx, client, queue, now)
+		promoteWaitlist(ctx, client, queue)
+		dismissIdleQueue(ctx, client, queue, now)
+	}
+}
+
+// dismissIdleQueue 队列为空且无人在岛超过 _queueIdleTimeout 时，自动解散
+func dismissIdleQueue(ctx context.Context, client *firestore.Client, queue *storage.OnboardQueue, now time.Time) {
+	if _queueIdleTimeout <= 0 {
+		return
+	}
+	// 抽签报名期间即使还没有人报名也不算闲置
+	if queue.Len() > 0 || queue.LandedLen() > 0 || queue.IsLotteryPending() {
+		if !queue.IdleSince.IsZero() {
+			if err := queue.UpdateSetting(ctx, client, "IdleSince", firestore.Delete); err != nil {
+				_logger.Error().Err(err).Str("queue", queue.ID).Msg("clear IdleSince failed")
+			}
+		}
+		return
+	}
+	if queue.IdleSince.IsZero() {
+		if err := queue.UpdateSetting(ctx, client, "IdleSince", now); err != nil {
+			_logger.Error().Err(err).Str("queue", queue.ID).Msg("set IdleSince failed")
+		}
+		return
+	}
+	if now.Sub(queue.IdleSince) < _queueIdleTimeout {
+		return
+	}
+	island, _, err := storage.GetAnimalCrossingIslandByUserID(ctx, int(queue.OwnerID))
+	if err != nil && status.Code(err) != codes.NotFound {
+		_logger.Error().Err(err).Str("queue", queue.ID).Msg("dismissIdleQueue GetAnimalCrossingIslandByUserID")
+		return
+	}
+	if island == nil || island.OnBoardQueueID != queue.ID {
+		// 岛屿已不再指向这个队列，直接删除
+		if err = dismissQueue(ctx, client, queue); err != nil {
+			_logger.Error().Err(err).Str("queue", queue.ID).Msg("delete orphan queue failed")
+		}
+		return
+	}
+	dismissed, err := island.ClearIdleOnboardQueue(ctx, _queueIdleTimeout, now)
+	if err != nil {
+		if err.Error() != "queue is not idle" && status.Code(err) != codes.NotFound {
+			_logger.Error().Err(err).Str("queue", queue.ID).Msg("dismiss idle queue failed")
+		}
+		return
+	}
+	_, err = tgbot.Send(tgbotapi.NewMessage(queue.OwnerID,
+		fmt.Sprintf("前往 %s 的队列已经 %d 分钟没有人排队或在岛，已自动解散狸。\n如有需要请使用 /queue [密码] 重新创建队列", queue.Name, int(_queueIdleTimeout.Minutes()))))
+	if err != nil {
+		_logger.Info().Err(err).Int64("uid", queue.OwnerID).Msg("notify owner idle queue dismissed failed")
+	}
+	finishDismissedQueue(dismissed)
+}
+
+// skipExpiredInvitees 跳过超时未确认的客人，并邀请下一位
+func skipExpiredInvitees(ctx context.Context, client *firestore.Client, queue *storage.OnboardQueue, now time.Time) {
+	if queue.Paused {
+		// 暂停期间客人无法登岛，不计超时，恢复时会重新计算时限
+		return
+	}
+	expired := queue.ExpiredInvitees(now)
+	if len(expired) == 0 {
+		return
+	}
+	var removed int
+	for _, g := range expired {
+		if err := queue.RemoveExpiredInvitee(ctx, client, g.UID, now); err != nil {
+			if err.Error() != "invite deadline not expired" {
+				_logger.Error().Err(err).Int64("uid", g.UID).Str("queue", queue.ID).Msg("remove expired invitee failed")
+			}
+			continue
+		}
+		removed++
+		recordGuestEvent(g.UID, storage.GuestEventTimeout)
+		logQueueEvent(queue, storage.QueueEventTimeout, g.UID, g.Name)
+		var joinBtn = tgbotapi.NewInlineKeyboardButtonData("再排一次："+queue.Name, "/join_"+queue.ID)
+		_, err := tgbot.Send(tgbotapi.MessageConfig{
+			BaseChat: tgbotapi.BaseChat{
+				ChatID:      g.UID,
+				ReplyMarkup: tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(joinBtn)),
+			},
+			Text: fmt.Sprintf("您没有在 %d 分钟内确认前往 %s，已被跳过狸。\n如果还想前往，请重新排队。", queue.InviteTimeout, queue.Name),
+		})
+		if err != nil {
+			_logger.Info().Err(err).Int64("uid", g.UID).Msg("notify expired invitee failed")
+		}
+		notifyHosts(queue, fmt.Sprintf("@%s 超时未确认，已自动跳过\n队列剩余：%d\n当前在岛：%d", g.Name, queue.Len(), queue.LandedLen()))
+	}
+	// 先移除所有超时的客人再邀请，自助队列一次补满空位，避免超过在岛人数上限
+	invites := removed
+	if queue.IsAuto && queue.MaxGuestCount > 0 {
+		invites = 1
+	}
+	for ; invites > 0 && queue.Len() > 0 && (queue.MaxGuestCount == 0 || queue.LandedLen() < queue.MaxGuestCount); invites-- {
+		if err := sendNotify(ctx, client, queue); err != nil {
+			_logger.Info().Err(err).Str("queue", queue.ID).Msg("invite next after timeout failed")
+			return
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package chatbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/doylecnn/new-nsfc-bot/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	// sellQueuePasswordPrompt 一键开启卖菜队列时，要求岛主回复密码的提示
+	sellQueuePasswordPrompt = "请输入开岛密码，将以此菜价开启卖菜队列："
+	// defaultSellQueueMaxGuestCount 卖菜队列默认的同时登岛客人数
+	defaultSellQueueMaxGuestCount = 3
+)
+
+// cmdSetSellQueuePrice 设置自动提示开启卖菜队列的菜价
+func cmdSetSellQueuePrice(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig, err error) {
+	ctx := context.Background()
+	island, _, err := storage.GetAnimalCrossingIslandByUserID(ctx, message.From.ID)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, Error{InnerError: err,
+				ReplyText: "您还没有登记您的岛屿，请用/addisland 添加您的岛屿信息",
+			}
+		}
+		return nil, Error{InnerError: err,
+			ReplyText: "查询岛屿时出错了。",
+		}
+	}
+	argstr := strings.TrimSpace(message.CommandArguments())
+	if len(argstr) == 0 {
+		var replyText string
+		if island.SellQueuePrice > 0 {
+			replyText = fmt.Sprintf("当前菜价达到 %d 时，会提示您一键开启卖菜队列。\n/sellqueue 0 关闭提示", island.SellQueuePrice)
+		} else {
+			replyText = "尚未设置卖菜队列菜价。\n/sellqueue [菜价] 在 /dtcj 报价达到此菜价时，提示您一键开启卖菜队列"
+		}
+		return []tgbotapi.MessageConfig{{
+				BaseChat: tgbotapi.BaseChat{
+					ChatID:              message.Chat.ID,
+					ReplyToMessageID:    message.MessageID,
+					DisableNotification: true},
+				Text: replyText}},
+			nil
+	}
+	price, err := strconv.Atoi(argstr)
+	if err != nil || price < 0 || price > 999 {
+		return nil, Error{InnerError: err,
+			ReplyText: "只接受[0-999]之间的整数菜价狸，0 表示关闭提示",
+		}
+	}
+	island.SellQueuePrice = price
+	if err = island.Update(ctx); err != nil {
+		return nil, Error{InnerError: err,
+			ReplyText: "保存卖菜队列菜价时出错狸",
+		}
+	}
+	var replyText = "已关闭卖菜队列提示狸"
+	if price > 0 {
+		replyText = fmt.Sprintf("已设置：/dtcj 报价达到 %d 时，会私聊提示您一键开启卖菜队列狸", price)
+	}
+	return []tgbotapi.MessageConfig{{
+			BaseChat: tgbotapi.BaseChat{
+				ChatID:              message.Chat.ID,
+				ReplyToMessageID:    message.MessageID,
+				DisableNotification: true},
+			Text: replyText}},
+		nil
+}
+
+// offerSellQueue 报价达到岛主设置的菜价时，私聊提供一键开启卖菜队列按钮
+func offerSellQueue(ctx context.Context, uid, price int) {
+	island, _, err := storage.GetAnimalCrossingIslandByUserID(ctx, uid)
+	if err != nil || island == nil {
+		return
+	}
+	if island.SellQueuePrice <= 0 || price < island.SellQueuePrice {
+		return
+	}
+	if island.LastPrice.LocationDateTime().Weekday() == 0 {
+		return
+	}
+	if len(island.OnBoardQueueID) > 0 {
+		return
+	}
+	var sellBtn = tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("开启卖菜队列：%d", price), fmt.Sprintf("/sellqueue_%d", price))
+	var cancelBtn = tgbotapi.NewInlineKeyboardButtonData("取消", "/cancel")
+	_, err = tgbot.Send(tgbotapi.MessageConfig{
+		BaseChat: tgbotapi.BaseChat{
+			ChatID:      int64(uid),
+			ReplyMarkup: tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(sellBtn), tgbotapi.NewInlineKeyboardRow(cancelBtn)),
+		},
+		Text: fmt.Sprintf("您的菜价 %d 已达到设置的 %d，要开启卖菜队列吗？", price, island.SellQueuePrice),
+	})
+	if err != nil {
+		_logger.Info().Err(err).Int("uid", uid).Msg("offer sell queue failed")
+	}
+}
+
+func callbackQuerySellQueue(query *tgbotapi.CallbackQuery) (callbackConfig tgbotapi.CallbackConfig, err error) {
+	price, err := strconv.Atoi(query.Data[11:])
+	if err != nil {
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "wrong parameters",
+			ShowAlert:       false,
+		}, nil
+	}
+	tgbot.DeleteMessage(tgbotapi.NewDeleteMessage(int64(query.From.ID), query.Message.MessageID))
+	_, err = tgbot.Send(tgbotapi.MessageConfig{
+		BaseChat: tgbotapi.BaseChat{
+			ChatID:      int64(query.From.ID),
+			ReplyMarkup: tgbotapi.ForceReply{ForceReply: true, Selective: true},
+		},
+		Text: fmt.Sprintf("%s%d", sellQueuePasswordPrompt, price),
+	})
+	if err != nil {
+		_logger.Error().Err(err).Int("uid", query.From.ID).Msg("send sell queue password prompt failed")
+		return tgbotapi.CallbackConfig{
+			CallbackQueryID: query.ID,
+			Text:            "failed",
+			ShowAlert:       false,
+		}, nil
+	}
+	err = errors.New("no_alert")
+	return
+}
+
+// cmdOpenSellQueue 岛主回复密码后，开启卖菜队列并分享到菜价上榜的群
+func cmdOpenSellQueue(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig, err error) {
+	if !message.Chat.IsPrivate() {
+		return
+	}
+	price, err := strconv.Atoi(strings.TrimPrefix(message.ReplyToMessage.Text, sellQueuePasswordPrompt))
+	if err != nil {
+		return nil, Error{InnerError: err,
+			ReplyText: "无法识别菜价狸",
+		}
+	}
+	password, notice, err := parseDodoCode(message.Text)
+	if err != nil {
+		return []tgbotapi.MessageConfig{
+			{
+				BaseChat: tgbotapi.BaseChat{
+					ChatID: message.Chat.ID,
+				},
+				Text: err.Error(),
+			},
+			{
+				BaseChat: tgbotapi.BaseChat{
+					ChatID:      message.Chat.ID,
+					ReplyMarkup: tgbotapi.ForceReply{ForceReply: true, Selective: true},
+				},
+				Text: fmt.Sprintf("%s%d", sellQueuePasswordPrompt, price),
+			},
+		}, nil
+	}
+	ctx := context.Background()
+	island, residentUID, err := storage.GetAnimalCrossingIslandByUserID(ctx, message.From.ID)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, Error{InnerError: err,
+				ReplyText: "没有找到您的岛屿信息狸，如未记录，请先使用/addisland 登记岛屿信息狸。",
+			}
+		}
+		return nil, Error{InnerError: err,
+			ReplyText: "查询记录时出错狸",
+		}
+	}
+	if len(island.OnBoardQueueID) != 0 {
+		queue, _ := island.GetOnboardQueue(ctx)
+		if queue != nil && !queue.Dismissed {
+			return nil, Error{InnerError: err,
+				ReplyText: "请先 /dismiss 解散您当前已发起的队列",
+			}
+		}
+		if _, err = island.ClearOldOnboardQueue(ctx); err != nil {
+			return nil, Error{InnerError: err,
+				ReplyText: "清理旧队列时出错狸",
+			}
+		}
+	}
+	uid := message.From.ID
+	if residentUID > 0 {
+		uid = residentUID
+	}
+	owner := message.From.UserName
+	if len(owner) == 0 {
+		owner = message.From.FirstName
+	}
+	island.AirportIsOpen = true
+	island.OpenTime = time.Now()
+	island.Info = fmt.Sprintf("大头菜收购价：%d", price)
+	if err = island.Update(ctx); err != nil {
+		return nil, Error{InnerError: err,
+			ReplyText: "更新岛屿开放信息时出错狸",
+		}
+	}
+	queue, err := island.CreateOnboardQueue(ctx, int64(uid), owner, password, defaultSellQueueMaxGuestCount)
+	if err != nil {
+		_logger.Error().Err(err).Msg("创建卖菜队列时出错")
+		return nil, Error{InnerError: err,
+			ReplyText: "创建队列时出错狸",
+		}
+	}
+	tgbot.DeleteMessage(tgbotapi.NewDeleteMessage(message.Chat.ID, message.ReplyToMessage.MessageID))
+
+	sharedGroups := shareSellQueueToRankedGroups(ctx, message.From.ID, island, queue)
+	var replyText = fmt.Sprintf("卖菜队列已创建成功，菜价：%d，密码：%s\n同时登岛客人数：%d\n", price, dodoCodeText(password, notice), queue.MaxGuestCount)
+	if len(sharedGroups) > 0 {
+		replyText += fmt.Sprintf("已分享到菜价上榜的群：%s\n", strings.Join(sharedGroups, "、"))
+	} else {
+		replyText += "您的菜价目前不在任何群的高价排行中，请使用分享按钮选择要分享排队的群/朋友\n"
+	}
+	replyText += "/dismiss 立即解散队列\n/myqueue 列出自己创建的队列"
+	return []tgbotapi.MessageConfig{{
+			BaseChat: tgbotapi.BaseChat{
+				ChatID:              message.Chat.ID,
+				DisableNotification: true,
+				ReplyMarkup:         queueOwnerReplyMarkup(queue),
+			},
+			Text: replyText,
+		}},
+		nil
+}
+
+// shareSellQueueToRankedGroups 在报价进入高价排行的群里发布排队入口，返回已分享的群名
+func shareSellQueueToRankedGroups(ctx context.Context, uid int, island *storage.Island, queue *storage.OnboardQueue) (sharedGroups []string) {
+	u, err := storage.GetUser(ctx, uid, 0)
+	if err != nil {
+		_logger.Warn().Err(err).Int("uid", uid).Msg("shareSellQueueToRankedGroups GetUser")
+		return
+	}
+	localtime := time.Now().In(island.Timezone.Location())
+	for _, gid := range u.GroupIDs {
+		topPriceUsers, _, _, err := getTopPriceUsersAndLowestPriceUser(ctx, gid, localtime)
+		if err != nil {
+			continue
+		}
+		var ranked = false
+		for _, tu := range topPriceUsers {
+			if tu.ID == uid {
+				ranked = true
+				break
+			}
+		}
+		if !ranked {
+			continue
+		}
+		var joinBtn = tgbotapi.NewInlineKeyboardButtonURL("加入队列", queueJoinURLPrefix+queue.ID)
+		_, err = tgbot.Send(tgbotapi.MessageConfig{
+			BaseChat: tgbotapi.BaseChat{
+				ChatID:      gid,
+				ReplyMarkup: tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(joinBtn)),
+			},
+			Text: fmt.Sprintf("%s 的 %s 正在高价收购大头菜！\n本次信息：%s\n点击“加入队列”按钮后，请再点击“start”按钮", u.Name, island.Name, island.Info),
+		})
+		if err != nil {
+			_logger.Warn().Err(err).Int64("gid", gid).Msg("share sell queue failed")
+			continue
+		}
+		if err = recordSellQueueShare(ctx, queue, gid); err != nil {
+			_logger.Warn().Err(err).Int64("gid", gid).Msg("record sell queue share failed")
+		}
+		group, err := storage.GetGroup(ctx, gid)
+		if err != nil || len(group.Title) == 0 {
+			sharedGroups = append(sharedGroups, strconv.FormatInt(gid, 10))
+		} else {
+			sharedGroups = append(sharedGroups, group.Title)
+		}
+	}
+	return
+}
+
+// recordSellQueueShare 记录卖菜队列被分享到的群
+func recordSellQueueShare(ctx context.Context, queue *storage.OnboardQueue, gid int64) (err error) {
+	client, err := firestore.NewClient(ctx, _projectID)
+	if err != nil {
+		return
+	}
+	defer client.Close()
+	return queue.AddSharedChat(ctx, client, gid)
+}
@@ -0,0 +1,289 @@
+package chatbot
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/doylecnn/new-nsfc-bot/storage"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// 大头菜价格走势，参照游戏内的生成规则
+const (
+	turnipPatternFluctuating = iota // 波型
+	turnipPatternLargeSpike         // 三期型
+	turnipPatternDecreasing         // 递减型
+	turnipPatternSmallSpike         // 四期型
+)
+
+// 上周走势未知时，各走势的概率
+var turnipPatternProbabilities = []float64{0.346, 0.2475, 0.1473, 0.2592}
+
+var turnipPeriodNames = []string{"周一上午", "周一下午", "周二上午", "周二下午", "周三上午", "周三下午", "周四上午", "周四下午", "周五上午", "周五下午", "周六上午", "周六下午"}
+
+// turnipRate 某个时段菜价相对周日买入价的倍率范围
+type turnipRate struct {
+	Min float64
+	Max float64
+}
+
+// turnipVariant 一种可能的一周走势
+type turnipVariant struct {
+	Pattern int
+	Rates   [12]turnipRate
+	Peak    int // 大坑/小坑的最高价时段，其它走势为 -1
+	Weight  float64
+}
+
+// turnipForecast 一周菜价预测结果
+type turnipForecast struct {
+	SpikeProbability float64
+	PeakPeriod       int
+	PeakMinPrice     int
+	PeakMaxPrice     int
+}
+
+// decreasingRates 从 start 开始，每个时段下降 [minStep, maxStep] 的倍率
+func decreasingRates(rates []turnipRate, start turnipRate, minStep, maxStep float64) {
+	for i := range rates {
+		rates[i] = turnipRate{Min: start.Min - maxStep*float64(i), Max: start.Max - minStep*float64(i)}
+	}
+}
+
+func fillRates(rates []turnipRate, rate turnipRate) {
+	for i := range rates {
+		rates[i] = rate
+	}
+}
+
+// turnipVariants 列出所有可能的一周走势
+func turnipVariants() (variants []turnipVariant) {
+	var fluctuating []turnipVariant
+	for dec1 := 2; dec1 <= 3; dec1++ {
+		dec2 := 5 - dec1
+		for high1 := 0; high1 <= 6; high1++ {
+			high2and3 := 7 - high1
+			for high3 := 0; high3 < high2and3; high3++ {
+				high2 := high2and3 - high3
+				v := turnipVariant{Pattern: turnipPatternFluctuating, Peak: -1}
+				i := 0
+				fillRates(v.Rates[i:i+high1], turnipRate{0.9, 1.4})
+				i += high1
+				decreasingRates(v.Rates[i:i+dec1], turnipRate{0.6, 0.8}, 0.04, 0.1)
+				i += dec1
+				fillRates(v.Rates[i:i+high2], turnipRate{0.9, 1.4})
+				i += high2
+				decreasingRates(v.Rates[i:i+dec2], turnipRate{0.6, 0.8}, 0.04, 0.1)
+				i += dec2
+				fillRates(v.Rates[i:i+high3], turnipRate{0.9, 1.4})
+				fluctuating = append(fluctuating, v)
+			}
+		}
+	}
+
+	var largeSpike []turnipVariant
+	for start := 1; start <= 7; start++ {
+		v := turnipVariant{Pattern: turnipPatternLargeSpike, Peak: start + 2}
+		decreasingRates(v.Rates[:start], turnipRate{0.85, 0.9}, 0.03, 0.05)
+		v.Rates[start] = turnipRate{0.9, 1.4}
+		v.Rates[start+1] = turnipRate{1.4, 2.0}
+		v.Rates[start+2] = turnipRate{2.0, 6.0}
+		v.Rates[start+3] = turnipRate{1.4, 2.0}
+		v.Rates[start+4] = turnipRate{0.9, 1.4}
+		fillRates(v.Rates[start+5:], turnipRate{0.4, 0.9})
+		largeSpike = append(largeSpike, v)
+	}
+
+	decreasing := turnipVariant{Pattern: turnipPatternDecreasing, Peak: -1}
+	decreasingRates(decreasing.Rates[:], turnipRate{0.85, 0.9}, 0.03, 0.05)
+
+	var smallSpike []turnipVariant
+	for start := 0; start <= 7; start++ {
+		v := turnipVariant{Pattern: turnipPatternSmallSpike, Peak: start + 3}
+		decreasingRates(v.Rates[:start], turnipRate{0.4, 0.9}, 0.03, 0.05)
+		v.Rates[start] = turnipRate{0.9, 1.4}
+		v.Rates[start+1] = turnipRate{0.9, 1.4}
+		v.Rates[start+2] = turnipRate{1.4, 2.0}
+		v.Rates[start+3] = turnipRate{1.4, 2.0}
+		v.Rates[start+4] = turnipRate{1.4, 2.0}
+		decreasingRates(v.Rates[start+5:], turnipRate{0.4, 0.9}, 0.03, 0.05)
+		smallSpike = append(smallSpike, v)
+	}
+
+	for _, group := range [][]turnipVariant{fluctuating, largeSpike, {decreasing}, smallSpike} {
+		for _, v := range group {
+			v.Weight = turnipPatternProbabilities[v.Pattern] / float64(len(group))
+			variants = append(variants, v)
+		}
+	}
+	return
+}
+
+// priceRange 根据周日买入价计算价格范围，买入价未知时按 [90, 110] 计算
+func (r turnipRate) priceRange(buyPrice int) (min, max int) {
+	if buyPrice > 0 {
+		return int(math.Floor(r.Min*float64(buyPrice))) - 1, int(math.Ceil(r.Max*float64(buyPrice))) + 1
+	}
+	return int(math.Floor(r.Min*90)) - 1, int(math.Ceil(r.Max*110)) + 1
+}
+
+// weekPriceArray 将一周报价整理为周日买入价和周一至周六 12 个时段的价格，未报价为 0
+func weekPriceArray(priceHistory []storage.TurnipPrice) (buyPrice int, prices [12]int) {
+	for _, p := range priceHistory {
+		d := p.LocationDateTime()
+		wd := int(d.Weekday())
+		if wd == 0 {
+			buyPrice = p.Price
+		} else if d.Hour() < 12 {
+			prices[wd*2-2] = p.Price
+		} else {
+			prices[wd*2-1] = p.Price
+		}
+	}
+	return
+}
+
+// currentTurnipPeriod 当前所在的时段，周日为 -1
+func currentTurnipPeriod(now time.Time) int {
+	if now.Hour() < 5 {
+		now = now.Add(-(time.Duration(now.Hour()+1) * time.Hour))
+	}
+	wd := int(now.Weekday())
+	if wd == 0 {
+		return -1
+	}
+	if now.Hour() < 12 {
+		return wd*2 - 2
+	}
+	return wd*2 - 1
+}
+
+// forecastTurnipPrices 根据已有报价，预测从 fromPeriod 起出现大坑/小坑的概率与最可能的高峰时段
+func forecastTurnipPrices(priceHistory []storage.TurnipPrice, fromPeriod int) (forecast turnipForecast, ok bool) {
+	buyPrice, prices := weekPriceArray(priceHistory)
+	var total float64
+	var peakWeights [12]float64
+	var matched []turnipVariant
+	for _, v := range turnipVariants() {
+		fit := true
+		for i, price := range prices {
+			if price == 0 {
+				continue
+			}
+			min, max := v.Rates[i].priceRange(buyPrice)
+			if price < min || price > max {
+				fit = false
+				break
+			}
+		}
+		if !fit {
+			continue
+		}
+		total += v.Weight
+		matched = append(matched, v)
+		if v.Peak >= 0 && v.Peak >= fromPeriod {
+			forecast.SpikeProbability += v.Weight
+			peakWeights[v.Peak] += v.Weight
+		}
+	}
+	if total == 0 {
+		return forecast, false
+	}
+	forecast.SpikeProbability /= total
+	forecast.PeakPeriod = -1
+	for i, w := range peakWeights {
+		if w > 0 && (forecast.PeakPeriod < 0 || w > peakWeights[forecast.PeakPeriod]) {
+			forecast.PeakPeriod = i
+		}
+	}
+	if forecast.PeakPeriod >= 0 {
+		for _, v := range matched {
+			if v.Peak != forecast.PeakPeriod {
+				continue
+			}
+			min, max := v.Rates[v.Peak].priceRange(buyPrice)
+			if forecast.PeakMinPrice == 0 || min+1 < forecast.PeakMinPrice {
+				forecast.PeakMinPrice = min + 1
+			}
+			if max-1 > forecast.PeakMaxPrice {
+				forecast.PeakMaxPrice = max - 1
+			}
+		}
+	}
+	return forecast, true
+}
+
+// cmdDTCForecastInGroup 预测本群各岛接下来出现高价的可能
+func cmdDTCForecastInGroup(message *tgbotapi.Message) (replyMessage []tgbotapi.MessageConfig, err error) {
+	ctx := context.Background()
+	users, err := storage.GetGroupUsers(ctx, message.Chat.ID)
+	if err != nil {
+		_logger.Error().Err(err).Msg("GetGroupUsers")
+		return nil, Error{InnerError: err,
+			ReplyText: "查询群成员时出错狸",
+		}
+	}
+	type islandForecast struct {
+		User     storage.User
+		Forecast turnipForecast
+	}
+	var forecasts []islandForecast
+	for _, u := range users {
+		island, residentUID, err := u.GetAnimalCrossingIsland(ctx)
+		if err != nil || island == nil {
+			continue
+		}
+		uid := u.ID
+		if residentUID > 0 {
+			uid = residentUID
+		}
+		island.WeekPriceHistory = getIslandWeekPriceHistory(ctx, island, uid)
+		if len(island.WeekPriceHistory) == 0 {
+			continue
+		}
+		fromPeriod := currentTurnipPeriod(time.Now().In(island.Timezone.Location()))
+		forecast, ok := forecastTurnipPrices(island.WeekPriceHistory, fromPeriod)
+		if !ok || forecast.SpikeProbability <= 0 || forecast.PeakPeriod < 0 {
+			continue
+		}
+		u.Island = island
+		forecasts = append(forecasts, islandForecast{User: u, Forecast: forecast})
+	}
+	if len(forecasts) == 0 {
+		return []tgbotapi.MessageConfig{{
+				BaseChat: tgbotapi.BaseChat{
+					ChatID:              message.Chat.ID,
+					ReplyToMessageID:    message.MessageID,
+					DisableNotification: true},
+				Text: "本群本周的报价中，暂时看不出接下来会出现高价的岛屿狸"}},
+			nil
+	}
+	sort.Slice(forecasts, func(i, j int) bool {
+		return forecasts[i].Forecast.SpikeProbability > forecasts[j].Forecast.SpikeProbability
+	})
+	if len(forecasts) > 5 {
+		forecasts = forecasts[:5]
+	}
+	var lines []string
+	for i, f := range forecasts {
+		name := f.User.Island.Name
+		if !strings.HasSuffix(name, "岛") {
+			name += "岛"
+		}
+		lines = append(lines, fmt.Sprintf("%d. %s的 %s：出现大坑/小坑的概率 %.0f%%，最可能的高峰在%s，约 %d~%d",
+			i+1, f.User.Name, name, f.Forecast.SpikeProbability*100,
+			turnipPeriodNames[f.Forecast.PeakPeriod], f.Forecast.PeakMinPrice, f.Forecast.PeakMaxPrice))
+	}
+	return []tgbotapi.MessageConfig{{
+			BaseChat: tgbotapi.BaseChat{
+				ChatID:              message.Chat.ID,
+				ReplyToMessageID:    message.MessageID,
+				DisableNotification: true},
+			Text: fmt.Sprintf("本群接下来最可能出现高价的岛屿（前 %d）：\n%s\n预测仅根据本周已录入的报价，仅供参考狸", len(lines), strings.Join(lines, "\n"))}},
+		nil
+}
@@ -22,6 +22,10 @@ type env struct {
 	AppID      string
 	Domain     string
 	projectID  string
+
+	QueuePasswordKey string
+
+	QueueIdleMinutes int
 }
 
 func main() {
@@ -30,8 +34,11 @@ func main() {
 	rand.Seed(time.Now().Unix())
 
 	storage.InitLogger(env.projectID)
+	if err := storage.InitPasswordKey(env.QueuePasswordKey); err != nil {
+		log.Logger.Fatal().Err(err).Msg("invalid env var: QUEUE_PASSWORD_KEY")
+	}
 
-	bot := chatbot.NewChatBot(env.BotToken, env.Domain, env.AppID, env.projectID, env.Port, env.BotAdminID)
+	bot := chatbot.NewChatBot(env.BotToken, env.Domain, env.AppID, env.projectID, env.Port, env.BotAdminID, env.QueueIdleMinutes)
 	defer bot.Close()
 	web, updates := web.NewWeb(env.BotToken, env.Domain, env.AppID, env.projectID, env.Port, env.BotAdminID, bot)
 	defer web.Close()
@@ -76,5 +83,18 @@ func readEnv() env {
 		log.Logger.Fatal().Msg("no env var: DOMAIN")
 	}
 
-	return env{port, token, botAdminID, appID, domain, projectID}
+	queuePasswordKey := os.Getenv("QUEUE_PASSWORD_KEY")
+	if queuePasswordKey == "" {
+		log.Logger.Fatal().Msg("no env var: QUEUE_PASSWORD_KEY")
+	}
+
+	queueIdleMinutes := 30
+	if idle := os.Getenv("QUEUE_IDLE_MINUTES"); idle != "" {
+		queueIdleMinutes, err = strconv.Atoi(idle)
+		if err != nil || queueIdleMinutes < 0 {
+			log.Logger.Fatal().Err(err).Str("QUEUE_IDLE_MINUTES", idle).Msg("invalid env var: QUEUE_IDLE_MINUTES")
+		}
+	}
+
+	return env{port, token, botAdminID, appID, domain, projectID, queuePasswordKey, queueIdleMinutes}
 }
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// 客人在队列中的结局
+const (
+	GuestEventDone    = "Done"    // 完成登岛
+	GuestEventSorry   = "Sorry"   // 主动取消
+	GuestEventTimeout = "Timeout" // 超时未确认
+	GuestEventKicked  = "Kicked"  // 被岛主移出
+)
+
+// GuestReputation 客人的登岛记录
+type GuestReputation struct {
+	UID        int64     `firestore:"-"`
+	Done       int       `firestore:"Done"`
+	Sorry      int       `firestore:"Sorry"`
+	Timeout    int       `firestore:"Timeout"`
+	Kicked     int       `firestore:"Kicked"`
+	LastNoShow time.Time `firestore:"LastNoShow,omitempty"`
+}
+
+// HasNoShowSince return whether the guest did not show up after since
+func (r GuestReputation) HasNoShowSince(since time.Time) bool {
+	return !r.LastNoShow.IsZero() && r.LastNoShow.After(since)
+}
+
+// RecordGuestEvent add one event into guest's reputation
+func RecordGuestEvent(ctx context.Context, uid int64, event string) (err error) {
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return
+	}
+	defer client.Close()
+	var data = map[string]interface{}{
+		event: firestore.Increment(1),
+	}
+	if event == GuestEventTimeout {
+		data["LastNoShow"] = time.Now()
+	}
+	_, err = client.Doc("guestReputations/"+strconv.FormatInt(uid, 10)).Set(ctx, data, firestore.MergeAll)
+	return
+}
+
+// GetGuestReputations return reputations of guests, guests without any record are omitted
+func GetGuestReputations(ctx context.Context, uids []int64) (reputations map[int64]GuestReputation, err error) {
+	reputations = make(map[int64]GuestReputation)
+	if len(uids) == 0 {
+		return
+	}
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return
+	}
+	defer client.Close()
+	var refs []*firestore.DocumentRef
+	for _, uid := range uids {
+		refs = append(refs, client.Doc("guestReputations/"+strconv.FormatInt(uid, 10)))
+	}
+	snaps, err := client.GetAll(ctx, refs)
+	if err != nil {
+		return
+	}
+	for i, snap := range snaps {
+		if !snap.Exists() {
+			continue
+		}
+		var r GuestReputation
+		if err := snap.DataTo(&r); err != nil {
+			logger.Warn().Err(err).Msg("GetGuestReputations")
+			continue
+		}
+		r.UID = uids[i]
+		reputations[r.UID] = r
+	}
+	return
+}
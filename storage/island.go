@@ -54,6 +54,7 @@ type Island struct {
 	Owner            string        `firestore:"owner"`
 	OwnerInsensitive string        `firestore:"owner_insensitive"`
 	ResidentUID      int           `firestore:"resident_userid,omitempty"` // 指向真正的岛主
+	SellQueuePrice   int           `firestore:"SellQueuePrice"`            // 报价达到此价格时提示开启卖菜队列，0 为关闭
 	WeekPriceHistory []TurnipPrice `firestore:"-"`
 }
 
@@ -118,6 +119,9 @@ func (i Island) Update(ctx context.Context) (err error) {
 	return
 }
 
+// OnQueueDismissed 岛屿关闭时解散了队列后调用，由 chatbot 设置，用来通知客人并发送统计
+var OnQueueDismissed func(queue *OnboardQueue)
+
 // Close island
 func (i *Island) Close(ctx context.Context) (err error) {
 	client, err := firestore.NewClient(ctx, projectID)
@@ -126,9 +130,12 @@ func (i *Island) Close(ctx context.Context) (err error) {
 	}
 	defer client.Close()
 	if len(i.OnBoardQueueID) > 0 {
-		_, err = i.ClearOldOnboardQueue(ctx)
+		queue, err := i.ClearOldOnboardQueue(ctx)
 		if err != nil {
-			return
+			return err
+		}
+		if OnQueueDismissed != nil && len(queue.ID) > 0 {
+			OnQueueDismissed(queue)
 		}
 	}
 	i.AirportIsOpen = false
@@ -144,19 +151,12 @@ func (i *Island) CreateOnboardQueue(ctx context.Context, uid int64, owner, passw
 	}
 	defer client.Close()
 
-	queue = &OnboardQueue{Name: i.Name, IsAuto: maxGuestCount != 0, OwnerID: uid, Owner: owner, Password: password, IslandInfo: i.ShortInfo(), MaxGuestCount: maxGuestCount}
+	queue, err = i.newOnboardQueue(uid, owner, password, maxGuestCount)
+	if err != nil {
+		return nil, err
+	}
 	err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
-		ref := client.Collection("onboardQueues").NewDoc()
-		queue.ID = ref.ID
-		if err = tx.Create(ref, queue); err != nil {
-			return err
-		}
-		islandRef := client.Doc(i.Path)
-		return tx.Set(islandRef, map[string]interface{}{
-			"OnBoardQueueID": queue.ID,
-			"OpenTime":       time.Now(),
-			"AirportIsOpen":  true,
-		}, firestore.MergeAll)
+		return i.createOnboardQueue(client, tx, queue)
 	})
 	if err != nil {
 		logger.Info().Err(err).Msg("An error has occurred when CreateOnboardQueue")
@@ -164,6 +164,34 @@ func (i *Island) CreateOnboardQueue(ctx context.Context, uid int64, owner, passw
 	return
 }
 
+// newOnboardQueue build a new onboard queue for island, not saved yet
+func (i *Island) newOnboardQueue(uid int64, owner, password string, maxGuestCount int) (queue *OnboardQueue, err error) {
+	queue = &OnboardQueue{Name: i.Name, IsAuto: maxGuestCount != 0, OwnerID: uid, Owner: owner, IslandInfo: i.ShortInfo(), MaxGuestCount: maxGuestCount}
+	if err = queue.SetPassword(password); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// createOnboardQueue save queue, its log and open the island's airport in transaction
+func (i *Island) createOnboardQueue(client *firestore.Client, tx *firestore.Transaction, queue *OnboardQueue) (err error) {
+	ref := client.Collection("onboardQueues").NewDoc()
+	queue.ID = ref.ID
+	if err = tx.Create(ref, queue); err != nil {
+		return err
+	}
+	logRef := client.Doc("queueLogs/" + queue.ID)
+	if err = tx.Set(logRef, QueueLog{Name: queue.Name, OwnerID: queue.OwnerID, Owner: queue.Owner, CreatedAt: time.Now()}); err != nil {
+		return err
+	}
+	islandRef := client.Doc(i.Path)
+	return tx.Set(islandRef, map[string]interface{}{
+		"OnBoardQueueID": queue.ID,
+		"OpenTime":       time.Now(),
+		"AirportIsOpen":  true,
+	}, firestore.MergeAll)
+}
+
 // GetOnboardQueue return a exists OnboardQueue
 func (i *Island) GetOnboardQueue(ctx context.Context) (queue *OnboardQueue, err error) {
 	if len(i.OnBoardQueueID) == 0 {
@@ -179,6 +207,22 @@ func (i *Island) GetOnboardQueue(ctx context.Context) (queue *OnboardQueue, err
 
 // ClearOldOnboardQueue clean old onboard island queue
 func (i *Island) ClearOldOnboardQueue(ctx context.Context) (queue *OnboardQueue, err error) {
+	return i.clearOnboardQueue(ctx, nil)
+}
+
+// ClearIdleOnboardQueue clean onboard island queue which has been idle for idleTimeout,
+// idle state is checked again in transaction, so the queue is dismissed only once
+func (i *Island) ClearIdleOnboardQueue(ctx context.Context, idleTimeout time.Duration, now time.Time) (queue *OnboardQueue, err error) {
+	return i.clearOnboardQueue(ctx, func(q *OnboardQueue) error {
+		if len(q.Queue) > 0 || len(q.Landed) > 0 || q.IsLotteryPending() || q.IdleSince.IsZero() || now.Sub(q.IdleSince) < idleTimeout {
+			return errors.New("queue is not idle")
+		}
+		return nil
+	})
+}
+
+// clearOnboardQueue clean onboard island queue, check is called in transaction before the queue is deleted
+func (i *Island) clearOnboardQueue(ctx context.Context, check func(q *OnboardQueue) error) (queue *OnboardQueue, err error) {
 	client, err := firestore.NewClient(ctx, projectID)
 	if err != nil {
 		return
@@ -193,6 +237,9 @@ func (i *Island) ClearOldOnboardQueue(ctx context.Context) (queue *OnboardQueue,
 			ref := client.Doc("onboardQueues/" + i.OnBoardQueueID)
 			doc, err := tx.Get(ref)
 			if err != nil {
+				if check != nil {
+					return err
+				}
 				if status.Code(err) == codes.NotFound {
 					return tx.Set(islandRef, map[string]interface{}{
 						"OnBoardQueueID": "",
@@ -200,16 +247,22 @@ func (i *Island) ClearOldOnboardQueue(ctx context.Context) (queue *OnboardQueue,
 				}
 				return nil
 			}
-			err = tx.Set(islandRef, map[string]interface{}{
-				"OnBoardQueueID": "",
-			}, firestore.MergeAll)
+			err = doc.DataTo(queue)
 			if err != nil {
 				return err
 			}
-			err = doc.DataTo(queue)
+			if check != nil {
+				if err = check(queue); err != nil {
+					return err
+				}
+			}
+			err = tx.Set(islandRef, map[string]interface{}{
+				"OnBoardQueueID": "",
+			}, firestore.MergeAll)
 			if err != nil {
 				return err
 			}
+			queue.ID = doc.Ref.ID
 			queue.Dismissed = true
 			return tx.Delete(ref)
 		}
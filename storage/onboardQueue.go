@@ -3,11 +3,23 @@ package storage
 import (
 	"context"
 	"errors"
+	"strconv"
+	"time"
 
 	"cloud.google.com/go/firestore"
 	"google.golang.org/api/iterator"
 )
 
+// 队列成员对客人的可见范围
+const (
+	QueuePrivacyOwner  = "owner"  // 仅岛主可见
+	QueuePrivacyCount  = "count"  // 客人只能看到人数
+	QueuePrivacyPublic = "public" // 所有人可见
+)
+
+// maxVisitDurations 计算平均登岛时长时，保留的最近记录数
+const maxVisitDurations = 10
+
 type guest struct {
 	UID  int64  `firestore:"UID"`
 	Name string `firestore:"Name"`
@@ -27,6 +39,56 @@ type OnboardQueue struct {
 	UIDs          []int64 `firestore:"uids"`   //private chat id
 	Landed        []guest `firestore:"landed"` //landed
 	Dismissed     bool    `firestore:"Dismissed"`
+
+	InviteTimeout      int                  `firestore:"InviteTimeout"`       // 被邀请后确认“准备起飞！”的时限，单位分钟，0 为不限
+	InviteDeadlines    map[string]time.Time `firestore:"InviteDeadlines"`     // uid -> 确认时限
+	SharedChatIDs      []int64              `firestore:"SharedChatIDs"`       // 队列被分享到的群，非空时只有这些群的成员可以加入
+	IdleSince          time.Time            `firestore:"IdleSince,omitempty"` // 队列为空且无人在岛的开始时间
+	OnlyReliableGuests bool                 `firestore:"OnlyReliableGuests"`  // 仅限近期没有超时未到记录的客人加入
+	LandedTimes        map[string]time.Time `firestore:"LandedTimes"`         // uid -> 被邀请登岛的时间
+	VisitDurations     []float64            `firestore:"VisitDurations"`      // 最近几位客人的登岛时长，单位秒
+	VisitCount         int                  `firestore:"VisitCount"`          // 已完成登岛的客人数
+	MemberPrivacy      string               `firestore:"MemberPrivacy"`       // 队列成员对客人的可见范围，见 QueuePrivacy*
+	CoHostIDs          []int64              `firestore:"CoHostIDs"`           // 协作岛主
+	CoHostNames        map[string]string    `firestore:"CoHostNames"`         // uid -> 协作岛主名字
+	CoHostInviteToken  string               `firestore:"CoHostInviteToken"`   // 邀请协作岛主链接中的 token
+	MaxQueueLength     int                  `firestore:"MaxQueueLength"`      // 队列最大长度，0 为不限，满员后加入候补
+	Waitlist           []guest              `firestore:"waitlist"`            // 候补名单，有空位时按顺序转入队列
+	Intro              string               `firestore:"Intro"`               // 岛主给客人的说明，客人加入队列时显示
+	SharedCardIDs      []string             `firestore:"SharedCardIDs"`       // 通过 inline 分享出去的队列卡片的 inline message id
+	Paused             bool                 `firestore:"Paused"`              // 暂停邀请，客人仍可加入排队
+	RejoinDeadlines    map[string]time.Time `firestore:"RejoinDeadlines"`     // uid -> 炸岛后在岛客人回复的时限
+
+	LotteryEndsAt time.Time `firestore:"LotteryEndsAt,omitempty"` // 抽签报名截止时间，非零时为抽签队列，截止后抽签决定顺序
+	LotterySlots  int       `firestore:"LotterySlots"`            // 抽中的人数，0 为所有人都抽签排序
+	LotterySeed   string    `firestore:"LotterySeed"`             // 抽签种子，抽签后公布
+
+	GuestPurposes  map[string]string `firestore:"GuestPurposes"`  // uid -> 登岛目的，见 GuestPurpose*
+	RemainingTrips map[string]int    `firestore:"RemainingTrips"` // uid -> 剩余登岛次数，包括当前这一次
+
+	LandedUIDs []int64 `firestore:"LandedUIDs"` // 在岛客人的 uid，用于按客人查询所在的队列
+}
+
+// GetAllOnboardQueues return all onboard queues not dismissed
+func GetAllOnboardQueues(ctx context.Context, client *firestore.Client) (queues []*OnboardQueue, err error) {
+	iter := client.Collection("onboardQueues").Where("Dismissed", "==", false).Documents(ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		q := &OnboardQueue{}
+		if err = doc.DataTo(q); err != nil {
+			logger.Warn().Err(err).Msg("GetAllOnboardQueues")
+			continue
+		}
+		q.ID = doc.Ref.ID
+		queues = append(queues, q)
+	}
+	return queues, nil
 }
 
 // GetJoinedQueue return joined onboard queue
@@ -56,6 +118,31 @@ func GetJoinedQueue(ctx context.Context, uid int64) (queue []OnboardQueue, err e
 	return queue, nil
 }
 
+// GetCoHostedQueues return queues which uid is co-host of
+func GetCoHostedQueues(ctx context.Context, client *firestore.Client, uid int64) (queues []*OnboardQueue, err error) {
+	iter := client.Collection("onboardQueues").Where("CoHostIDs", "array-contains", uid).Documents(ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		q := &OnboardQueue{}
+		if err = doc.DataTo(q); err != nil {
+			logger.Warn().Err(err).Msg("GetCoHostedQueues")
+			continue
+		}
+		if q.Dismissed {
+			continue
+		}
+		q.ID = doc.Ref.ID
+		queues = append(queues, q)
+	}
+	return queues, nil
+}
+
 // GetOnboardQueue return a exists OnboardQueue
 func GetOnboardQueue(ctx context.Context, client *firestore.Client, queueID string) (queue *OnboardQueue, err error) {
 	snap, err := client.Doc("onboardQueues/" + queueID).Get(ctx)
@@ -104,6 +191,167 @@ func (q *OnboardQueue) LandedLen() int {
 	return len(q.Landed)
 }
 
+// AddSharedCard record the inline message id of shared queue card
+func (q *OnboardQueue) AddSharedCard(ctx context.Context, client *firestore.Client, inlineMessageID string) (err error) {
+	if q == nil || len(q.ID) == 0 {
+		return errors.New("queue not exists")
+	}
+	for _, id := range q.SharedCardIDs {
+		if id == inlineMessageID {
+			return
+		}
+	}
+	_, err = client.Doc("onboardQueues/"+q.ID).Update(ctx, []firestore.Update{
+		{Path: "SharedCardIDs", Value: firestore.ArrayUnion(inlineMessageID)},
+	})
+	if err != nil {
+		return
+	}
+	q.SharedCardIDs = append(q.SharedCardIDs, inlineMessageID)
+	return
+}
+
+// AddSharedChat record the group which this queue was shared into
+func (q *OnboardQueue) AddSharedChat(ctx context.Context, client *firestore.Client, chatID int64) (err error) {
+	if q == nil || len(q.ID) == 0 {
+		return errors.New("queue not exists")
+	}
+	for _, id := range q.SharedChatIDs {
+		if id == chatID {
+			return
+		}
+	}
+	_, err = client.Doc("onboardQueues/"+q.ID).Update(ctx, []firestore.Update{
+		{Path: "SharedChatIDs", Value: firestore.ArrayUnion(chatID)},
+	})
+	if err != nil {
+		return
+	}
+	q.SharedChatIDs = append(q.SharedChatIDs, chatID)
+	return
+}
+
+// Privacy return member privacy of this queue, default is QueuePrivacyOwner
+func (q *OnboardQueue) Privacy() string {
+	if q == nil || (q.MemberPrivacy != QueuePrivacyCount && q.MemberPrivacy != QueuePrivacyPublic) {
+		return QueuePrivacyOwner
+	}
+	return q.MemberPrivacy
+}
+
+// IsHost return whether uid is the owner or a co-host of this queue
+func (q *OnboardQueue) IsHost(uid int64) bool {
+	if q == nil {
+		return false
+	}
+	if q.OwnerID == uid {
+		return true
+	}
+	for _, id := range q.CoHostIDs {
+		if id == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// HostIDs return owner and co-hosts of this queue
+func (q *OnboardQueue) HostIDs() []int64 {
+	if q == nil {
+		return nil
+	}
+	return append([]int64{q.OwnerID}, q.CoHostIDs...)
+}
+
+// AddCoHost add uid as co-host of this queue
+func (q *OnboardQueue) AddCoHost(ctx context.Context, client *firestore.Client, uid int64, name string) (err error) {
+	if q == nil || len(q.ID) == 0 {
+		return errors.New("queue not exists")
+	}
+	if q.IsHost(uid) {
+		return errors.New("already host")
+	}
+	key := strconv.FormatInt(uid, 10)
+	_, err = client.Doc("onboardQueues/"+q.ID).Update(ctx, []firestore.Update{
+		{Path: "CoHostIDs", Value: firestore.ArrayUnion(uid)},
+		{FieldPath: firestore.FieldPath{"CoHostNames", key}, Value: name},
+	})
+	if err != nil {
+		return
+	}
+	q.CoHostIDs = append(q.CoHostIDs, uid)
+	if q.CoHostNames == nil {
+		q.CoHostNames = make(map[string]string)
+	}
+	q.CoHostNames[key] = name
+	return
+}
+
+// RemoveCoHost remove uid from co-hosts of this queue
+func (q *OnboardQueue) RemoveCoHost(ctx context.Context, client *firestore.Client, uid int64) (err error) {
+	if q == nil || len(q.ID) == 0 {
+		return errors.New("queue not exists")
+	}
+	key := strconv.FormatInt(uid, 10)
+	_, err = client.Doc("onboardQueues/"+q.ID).Update(ctx, []firestore.Update{
+		{Path: "CoHostIDs", Value: firestore.ArrayRemove(uid)},
+		{FieldPath: firestore.FieldPath{"CoHostNames", key}, Value: firestore.Delete},
+	})
+	if err != nil {
+		return
+	}
+	for i, id := range q.CoHostIDs {
+		if id == uid {
+			q.CoHostIDs = append(q.CoHostIDs[:i], q.CoHostIDs[i+1:]...)
+			break
+		}
+	}
+	delete(q.CoHostNames, key)
+	return
+}
+
+// IsLanded return whether the guest has been invited to the island
+func (q *OnboardQueue) IsLanded(uid int64) bool {
+	if q == nil {
+		return false
+	}
+	for _, p := range q.Landed {
+		if p.UID == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateSetting update a single setting field of OnboardQueue
+func (q *OnboardQueue) UpdateSetting(ctx context.Context, client *firestore.Client, path string, value interface{}) (err error) {
+	if q == nil || len(q.ID) == 0 {
+		return errors.New("queue not exists")
+	}
+	_, err = client.Doc("onboardQueues/"+q.ID).Update(ctx, []firestore.Update{
+		{Path: path, Value: value},
+	})
+	return
+}
+
+// GetGuestName return name of guest in queue or landed
+func (q *OnboardQueue) GetGuestName(uid int64) (name string, ok bool) {
+	if q == nil {
+		return
+	}
+	for _, p := range q.Queue {
+		if p.UID == uid {
+			return p.Name, true
+		}
+	}
+	for _, p := range q.Landed {
+		if p.UID == uid {
+			return p.Name, true
+		}
+	}
+	return
+}
+
 // GetPosition GetPosition
 func (q *OnboardQueue) GetPosition(uid int64) (int, error) {
 	for i, id := range q.UIDs {
@@ -157,10 +405,12 @@ func (q *OnboardQueue) Remove(ctx context.Context, client *firestore.Client, uid
 	var inQueue = false
 	var onLand = false
 	var deleteItem guest
+	var inQueueIdx int = -1
 	var onLandIdx int = -1
-	for _, p := range q.Queue {
+	for i, p := range q.Queue {
 		if p.UID == uid {
 			deleteItem = p
+			inQueueIdx = i
 			inQueue = true
 			break
 		}
@@ -183,19 +433,31 @@ func (q *OnboardQueue) Remove(ctx context.Context, client *firestore.Client, uid
 		{Path: "queue", Value: firestore.ArrayRemove(deleteItem)},
 		{Path: "uids", Value: firestore.ArrayRemove(uid)},
 		{Path: "landed", Value: firestore.ArrayRemove(deleteItem)},
+		{Path: "LandedUIDs", Value: firestore.ArrayRemove(uid)},
+		{FieldPath: firestore.FieldPath{"InviteDeadlines", strconv.FormatInt(uid, 10)}, Value: firestore.Delete},
+		{FieldPath: firestore.FieldPath{"LandedTimes", strconv.FormatInt(uid, 10)}, Value: firestore.Delete},
+		{FieldPath: firestore.FieldPath{"RejoinDeadlines", strconv.FormatInt(uid, 10)}, Value: firestore.Delete},
+		{FieldPath: firestore.FieldPath{"GuestPurposes", strconv.FormatInt(uid, 10)}, Value: firestore.Delete},
+		{FieldPath: firestore.FieldPath{"RemainingTrips", strconv.FormatInt(uid, 10)}, Value: firestore.Delete},
 	})
 	if err != nil {
 		return
 	}
-	if inQueue {
+	delete(q.InviteDeadlines, strconv.FormatInt(uid, 10))
+	delete(q.LandedTimes, strconv.FormatInt(uid, 10))
+	delete(q.RejoinDeadlines, strconv.FormatInt(uid, 10))
+	delete(q.GuestPurposes, strconv.FormatInt(uid, 10))
+	delete(q.RemainingTrips, strconv.FormatInt(uid, 10))
+	if inQueue && inQueueIdx > -1 {
 		if len(q.Queue) > 1 {
-			copy(q.Queue[0:], q.Queue[1:])
+			copy(q.Queue[inQueueIdx:], q.Queue[inQueueIdx+1:])
 			q.Queue = q.Queue[:len(q.Queue)-1]
 		} else {
 			q.Queue = []guest{}
 		}
 	}
 	if onLand && onLandIdx > -1 {
+		q.LandedUIDs = removeUID(q.LandedUIDs, uid)
 		if len(q.Landed) > 1 {
 			l := len(q.Landed) - 1
 			q.Landed[onLandIdx] = q.Landed[l]
@@ -227,12 +489,14 @@ func (q *OnboardQueue) Next(ctx context.Context, client *firestore.Client) (chat
 		{Path: "queue", Value: firestore.ArrayRemove(g)},
 		{Path: "uids", Value: firestore.ArrayRemove(g.UID)},
 		{Path: "landed", Value: firestore.ArrayUnion(g)},
+		{Path: "LandedUIDs", Value: firestore.ArrayUnion(g.UID)},
 	})
 	if err != nil {
 		return
 	}
 
 	q.Landed = append(q.Landed, g)
+	q.LandedUIDs = append(q.LandedUIDs, g.UID)
 	if len(q.Queue) > 1 {
 		copy(q.Queue[0:], q.Queue[1:])
 		q.Queue = q.Queue[:len(q.Queue)-1]
@@ -242,3 +506,272 @@ func (q *OnboardQueue) Next(ctx context.Context, client *firestore.Client) (chat
 
 	return
 }
+
+// SetInviteDeadline 邀请客人后，记录其确认的时限
+func (q *OnboardQueue) SetInviteDeadline(ctx context.Context, client *firestore.Client, uid int64) (err error) {
+	if q == nil || len(q.ID) == 0 || q.InviteTimeout <= 0 {
+		return
+	}
+	deadline := time.Now().Add(time.Duration(q.InviteTimeout) * time.Minute)
+	key := strconv.FormatInt(uid, 10)
+	_, err = client.Doc("onboardQueues/"+q.ID).Update(ctx, []firestore.Update{
+		{FieldPath: firestore.FieldPath{"InviteDeadlines", key}, Value: deadline},
+	})
+	if err != nil {
+		return
+	}
+	if q.InviteDeadlines == nil {
+		q.InviteDeadlines = make(map[string]time.Time)
+	}
+	q.InviteDeadlines[key] = deadline
+	return
+}
+
+// SetPaused 暂停/恢复邀请，恢复时重新计算已邀请客人的确认时限
+func (q *OnboardQueue) SetPaused(ctx context.Context, client *firestore.Client, paused bool) (err error) {
+	if q == nil || len(q.ID) == 0 {
+		return errors.New("queue not exists")
+	}
+	if q.Paused == paused {
+		return
+	}
+	updates := []firestore.Update{{Path: "Paused", Value: paused}}
+	var deadline time.Time
+	if !paused && q.InviteTimeout > 0 {
+		deadline = time.Now().Add(time.Duration(q.InviteTimeout) * time.Minute)
+		for key := range q.InviteDeadlines {
+			updates = append(updates, firestore.Update{FieldPath: firestore.FieldPath{"InviteDeadlines", key}, Value: deadline})
+		}
+	}
+	if _, err = client.Doc("onboardQueues/"+q.ID).Update(ctx, updates); err != nil {
+		return
+	}
+	q.Paused = paused
+	if !deadline.IsZero() {
+		for key := range q.InviteDeadlines {
+			q.InviteDeadlines[key] = deadline
+		}
+	}
+	return
+}
+
+// RecordLanded 记录客人被邀请登岛的时间
+func (q *OnboardQueue) RecordLanded(ctx context.Context, client *firestore.Client, uid int64) (err error) {
+	if q == nil || len(q.ID) == 0 {
+		return
+	}
+	now := time.Now()
+	key := strconv.FormatInt(uid, 10)
+	_, err = client.Doc("onboardQueues/"+q.ID).Update(ctx, []firestore.Update{
+		{FieldPath: firestore.FieldPath{"LandedTimes", key}, Value: now},
+	})
+	if err != nil {
+		return
+	}
+	if q.LandedTimes == nil {
+		q.LandedTimes = make(map[string]time.Time)
+	}
+	q.LandedTimes[key] = now
+	return
+}
+
+// RecordVisit 客人离岛时，记录其登岛时长
+// 在事务中读取最新的记录再追加，多位客人同时离岛时不会丢失
+func (q *OnboardQueue) RecordVisit(ctx context.Context, client *firestore.Client, uid int64) (err error) {
+	if q == nil || len(q.ID) == 0 {
+		return
+	}
+	var fresh OnboardQueue
+	var recorded bool
+	ref := client.Doc("onboardQueues/" + q.ID)
+	err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		recorded = false
+		dsnap, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		fresh = OnboardQueue{}
+		if err = dsnap.DataTo(&fresh); err != nil {
+			return err
+		}
+		landedTime, ok := fresh.LandedTimes[strconv.FormatInt(uid, 10)]
+		if !ok {
+			return nil
+		}
+		fresh.VisitDurations = append(fresh.VisitDurations, time.Since(landedTime).Seconds())
+		if len(fresh.VisitDurations) > maxVisitDurations {
+			fresh.VisitDurations = fresh.VisitDurations[len(fresh.VisitDurations)-maxVisitDurations:]
+		}
+		fresh.VisitCount++
+		recorded = true
+		return tx.Update(ref, []firestore.Update{
+			{Path: "VisitDurations", Value: fresh.VisitDurations},
+			{Path: "VisitCount", Value: fresh.VisitCount},
+		})
+	})
+	if err != nil || !recorded {
+		return
+	}
+	q.VisitDurations = fresh.VisitDurations
+	q.VisitCount = fresh.VisitCount
+	return
+}
+
+// AverageVisitDuration return rolling average visit duration of this queue
+func (q *OnboardQueue) AverageVisitDuration() (avg time.Duration, ok bool) {
+	if q == nil || len(q.VisitDurations) == 0 {
+		return 0, false
+	}
+	var total float64
+	for _, d := range q.VisitDurations {
+		total += d
+	}
+	return time.Duration(total / float64(len(q.VisitDurations)) * float64(time.Second)), true
+}
+
+// ClearInviteDeadline 客人确认后，清除其确认时限
+func (q *OnboardQueue) ClearInviteDeadline(ctx context.Context, client *firestore.Client, uid int64) (err error) {
+	if q == nil || len(q.ID) == 0 {
+		return
+	}
+	key := strconv.FormatInt(uid, 10)
+	if _, ok := q.InviteDeadlines[key]; !ok {
+		return
+	}
+	_, err = client.Doc("onboardQueues/"+q.ID).Update(ctx, []firestore.Update{
+		{FieldPath: firestore.FieldPath{"InviteDeadlines", key}, Value: firestore.Delete},
+	})
+	if err != nil {
+		return
+	}
+	delete(q.InviteDeadlines, key)
+	return
+}
+
+// ExpiredInvitees return landed guests who did not confirm before deadline
+func (q *OnboardQueue) ExpiredInvitees(now time.Time) (guests []guest) {
+	if q == nil {
+		return
+	}
+	for _, g := range q.Landed {
+		if deadline, ok := q.InviteDeadlines[strconv.FormatInt(g.UID, 10)]; ok && now.After(deadline) {
+			guests = append(guests, g)
+		}
+	}
+	return
+}
+
+// RemoveExpiredInvitee remove landed guest who did not confirm before deadline,
+// deadline is checked again in transaction, so each expired guest is removed only once
+func (q *OnboardQueue) RemoveExpiredInvitee(ctx context.Context, client *firestore.Client, uid int64, now time.Time) (err error) {
+	if q == nil || len(q.ID) == 0 {
+		return errors.New("queue not exists")
+	}
+	var fresh OnboardQueue
+	key := strconv.FormatInt(uid, 10)
+	ref := client.Doc("onboardQueues/" + q.ID)
+	err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		dsnap, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		fresh = OnboardQueue{}
+		if err = dsnap.DataTo(&fresh); err != nil {
+			return err
+		}
+		if fresh.Dismissed {
+			return errors.New("queue has been dismissed")
+		}
+		if deadline, ok := fresh.InviteDeadlines[key]; !ok || !now.After(deadline) {
+			return errors.New("invite deadline not expired")
+		}
+		landed := []guest{}
+		var found bool
+		for _, g := range fresh.Landed {
+			if g.UID == uid {
+				found = true
+				continue
+			}
+			landed = append(landed, g)
+		}
+		if !found {
+			return errors.New("not land island")
+		}
+		fresh.Landed = landed
+		return tx.Update(ref, []firestore.Update{
+			{Path: "landed", Value: fresh.Landed},
+			{Path: "LandedUIDs", Value: firestore.ArrayRemove(uid)},
+			{FieldPath: firestore.FieldPath{"InviteDeadlines", key}, Value: firestore.Delete},
+			{FieldPath: firestore.FieldPath{"LandedTimes", key}, Value: firestore.Delete},
+			{FieldPath: firestore.FieldPath{"RejoinDeadlines", key}, Value: firestore.Delete},
+			{FieldPath: firestore.FieldPath{"GuestPurposes", key}, Value: firestore.Delete},
+			{FieldPath: firestore.FieldPath{"RemainingTrips", key}, Value: firestore.Delete},
+		})
+	})
+	if err != nil {
+		return
+	}
+	q.Queue, q.UIDs, q.Landed = fresh.Queue, fresh.UIDs, fresh.Landed
+	q.LandedUIDs = removeUID(q.LandedUIDs, uid)
+	delete(q.InviteDeadlines, key)
+	delete(q.LandedTimes, key)
+	delete(q.RejoinDeadlines, key)
+	delete(q.GuestPurposes, key)
+	delete(q.RemainingTrips, key)
+	return
+}
+
+// Move guest to position to (start from 0) of OnboardQueue
+func (q *OnboardQueue) Move(ctx context.Context, client *firestore.Client, uid int64, to int) (err error) {
+	if q == nil || len(q.ID) == 0 {
+		return errors.New("queue not exists")
+	}
+	ref := client.Doc("onboardQueues/" + q.ID)
+	newQueue := &OnboardQueue{}
+	err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		if err = doc.DataTo(newQueue); err != nil {
+			return err
+		}
+		var from = -1
+		for i, p := range newQueue.Queue {
+			if p.UID == uid {
+				from = i
+				break
+			}
+		}
+		if from < 0 {
+			return errors.New("not join in this queue")
+		}
+		if to < 0 {
+			to = 0
+		} else if to >= len(newQueue.Queue) {
+			to = len(newQueue.Queue) - 1
+		}
+		g := newQueue.Queue[from]
+		if from < to {
+			copy(newQueue.Queue[from:to], newQueue.Queue[from+1:to+1])
+		} else {
+			copy(newQueue.Queue[to+1:from+1], newQueue.Queue[to:from])
+		}
+		newQueue.Queue[to] = g
+		newQueue.UIDs = make([]int64, len(newQueue.Queue))
+		for i, p := range newQueue.Queue {
+			newQueue.UIDs[i] = p.UID
+		}
+		return tx.Update(ref, []firestore.Update{
+			{Path: "queue", Value: newQueue.Queue},
+			{Path: "uids", Value: newQueue.UIDs},
+		})
+	})
+	if err != nil {
+		return
+	}
+	q.Queue = newQueue.Queue
+	q.UIDs = newQueue.UIDs
+	q.Landed = newQueue.Landed
+	return
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"strconv"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// BannedGuest 被岛主拉黑的客人
+type BannedGuest struct {
+	UID  int64
+	Name string
+}
+
+// queueBlacklist 岛主的黑名单，uid -> name
+type queueBlacklist struct {
+	Guests map[string]string `firestore:"Guests"`
+}
+
+// IsBannedByOwner return whether the guest has been banned by the queue owner
+func IsBannedByOwner(ctx context.Context, ownerID, uid int64) (banned bool, err error) {
+	guests, err := GetBannedGuests(ctx, ownerID)
+	if err != nil {
+		return
+	}
+	for _, g := range guests {
+		if g.UID == uid {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// GetBannedGuests return all guests banned by the queue owner
+func GetBannedGuests(ctx context.Context, ownerID int64) (guests []BannedGuest, err error) {
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return
+	}
+	defer client.Close()
+	snap, err := client.Doc("queueBlacklists/" + strconv.FormatInt(ownerID, 10)).Get(ctx)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, nil
+		}
+		return
+	}
+	var blacklist queueBlacklist
+	if err = snap.DataTo(&blacklist); err != nil {
+		return
+	}
+	for id, name := range blacklist.Guests {
+		uid, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			continue
+		}
+		guests = append(guests, BannedGuest{UID: uid, Name: name})
+	}
+	sort.Slice(guests, func(i, j int) bool { return guests[i].Name < guests[j].Name })
+	return
+}
+
+// BanGuest add the guest into the queue owner's blacklist
+func BanGuest(ctx context.Context, ownerID, uid int64, name string) (err error) {
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return
+	}
+	defer client.Close()
+	_, err = client.Doc("queueBlacklists/"+strconv.FormatInt(ownerID, 10)).Set(ctx, map[string]interface{}{
+		"Guests": map[string]interface{}{strconv.FormatInt(uid, 10): name},
+	}, firestore.MergeAll)
+	return
+}
+
+// UnbanGuest remove the guest from the queue owner's blacklist
+func UnbanGuest(ctx context.Context, ownerID, uid int64) (err error) {
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return
+	}
+	defer client.Close()
+	_, err = client.Doc("queueBlacklists/"+strconv.FormatInt(ownerID, 10)).Update(ctx, []firestore.Update{
+		{FieldPath: firestore.FieldPath{"Guests", strconv.FormatInt(uid, 10)}, Value: firestore.Delete},
+	})
+	if status.Code(err) == codes.NotFound {
+		err = nil
+	}
+	return
+}
@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// SetRejoinDeadlines 炸岛后，记录每位在岛客人回复的时限
+func (q *OnboardQueue) SetRejoinDeadlines(ctx context.Context, client *firestore.Client, deadline time.Time) (err error) {
+	if q == nil || len(q.ID) == 0 {
+		return errors.New("queue not exists")
+	}
+	if len(q.Landed) == 0 {
+		return
+	}
+	var updates []firestore.Update
+	for _, g := range q.Landed {
+		updates = append(updates, firestore.Update{FieldPath: firestore.FieldPath{"RejoinDeadlines", strconv.FormatInt(g.UID, 10)}, Value: deadline})
+	}
+	if _, err = client.Doc("onboardQueues/"+q.ID).Update(ctx, updates); err != nil {
+		return
+	}
+	if q.RejoinDeadlines == nil {
+		q.RejoinDeadlines = make(map[string]time.Time)
+	}
+	for _, g := range q.Landed {
+		q.RejoinDeadlines[strconv.FormatInt(g.UID, 10)] = deadline
+	}
+	return
+}
+
+// ClearRejoinDeadline 客人选择马上重新登岛后，清除其回复时限
+func (q *OnboardQueue) ClearRejoinDeadline(ctx context.Context, client *firestore.Client, uid int64) (err error) {
+	if q == nil || len(q.ID) == 0 {
+		return errors.New("queue not exists")
+	}
+	key := strconv.FormatInt(uid, 10)
+	if _, ok := q.RejoinDeadlines[key]; !ok {
+		return errors.New("not waiting for rejoin")
+	}
+	_, err = client.Doc("onboardQueues/"+q.ID).Update(ctx, []firestore.Update{
+		{FieldPath: firestore.FieldPath{"RejoinDeadlines", key}, Value: firestore.Delete},
+	})
+	if err != nil {
+		return
+	}
+	delete(q.RejoinDeadlines, key)
+	return
+}
+
+// ExpiredRejoins return landed guests who did not answer before rejoin deadline
+func (q *OnboardQueue) ExpiredRejoins(now time.Time) (guests []guest) {
+	if q == nil {
+		return
+	}
+	for _, g := range q.Landed {
+		if deadline, ok := q.RejoinDeadlines[strconv.FormatInt(g.UID, 10)]; ok && now.After(deadline) {
+			guests = append(guests, g)
+		}
+	}
+	return
+}
+
+// RequeueFront move landed guest back to the front of queue after rejoin deadline,
+// deadline is checked again in transaction, so each guest is requeued only once
+func (q *OnboardQueue) RequeueFront(ctx context.Context, client *firestore.Client, uid int64, now time.Time) (err error) {
+	if q == nil || len(q.ID) == 0 {
+		return errors.New("queue not exists")
+	}
+	var fresh OnboardQueue
+	key := strconv.FormatInt(uid, 10)
+	ref := client.Doc("onboardQueues/" + q.ID)
+	err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		dsnap, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		fresh = OnboardQueue{}
+		if err = dsnap.DataTo(&fresh); err != nil {
+			return err
+		}
+		if fresh.Dismissed {
+			return errors.New("queue has been dismissed")
+		}
+		if deadline, ok := fresh.RejoinDeadlines[key]; !ok || !now.After(deadline) {
+			return errors.New("rejoin deadline not expired")
+		}
+		landed := []guest{}
+		var requeued *guest
+		for i, g := range fresh.Landed {
+			if g.UID == uid {
+				requeued = &fresh.Landed[i]
+				continue
+			}
+			landed = append(landed, g)
+		}
+		if requeued == nil {
+			return errors.New("not land island")
+		}
+		fresh.Queue = append([]guest{*requeued}, fresh.Queue...)
+		fresh.UIDs = append([]int64{uid}, fresh.UIDs...)
+		fresh.Landed = landed
+		return tx.Update(ref, []firestore.Update{
+			{Path: "queue", Value: fresh.Queue},
+			{Path: "uids", Value: fresh.UIDs},
+			{Path: "landed", Value: fresh.Landed},
+			{Path: "LandedUIDs", Value: firestore.ArrayRemove(uid)},
+			{FieldPath: firestore.FieldPath{"RejoinDeadlines", key}, Value: firestore.Delete},
+			{FieldPath: firestore.FieldPath{"InviteDeadlines", key}, Value: firestore.Delete},
+			{FieldPath: firestore.FieldPath{"LandedTimes", key}, Value: firestore.Delete},
+		})
+	})
+	if err != nil {
+		return
+	}
+	q.Queue, q.UIDs, q.Landed = fresh.Queue, fresh.UIDs, fresh.Landed
+	q.LandedUIDs = removeUID(q.LandedUIDs, uid)
+	delete(q.RejoinDeadlines, key)
+	delete(q.InviteDeadlines, key)
+	delete(q.LandedTimes, key)
+	return
+}
@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+// 队列事件类型
+const (
+	QueueEventJoin     = "join"     // 加入队列
+	QueueEventLeave    = "leave"    // 离开队列
+	QueueEventInvite   = "invite"   // 被邀请登岛
+	QueueEventComing   = "coming"   // 确认准备起飞
+	QueueEventDone     = "done"     // 完成登岛
+	QueueEventSorry    = "sorry"    // 被邀请后取消
+	QueueEventTimeout  = "timeout"  // 超时未确认
+	QueueEventKick     = "kick"     // 被岛主移出
+	QueueEventPassword = "password" // 岛主修改密码
+	QueueEventToggle   = "toggle"   // 切换自动/手动队列
+	QueueEventPause    = "pause"    // 岛主暂停邀请
+	QueueEventResume   = "resume"   // 岛主恢复邀请
+	QueueEventCrash    = "crash"    // 炸岛，岛主更换密码
+	QueueEventRequeue  = "requeue"  // 炸岛后未回复的客人回到队首
+	QueueEventLottery  = "lottery"  // 抽签报名截止，没抽中的客人移出队列
+)
+
+// QueueEvent 队列事件，只追加不修改
+type QueueEvent struct {
+	Type        string    `firestore:"Type"`
+	UID         int64     `firestore:"UID"`
+	Name        string    `firestore:"Name"`
+	Time        time.Time `firestore:"Time"`
+	QueueLength int       `firestore:"QueueLength"` // 事件发生后队列中排队的人数
+}
+
+// QueueSummary 队列解散时的统计
+type QueueSummary struct {
+	Served       int           `firestore:"Served"`
+	NoShows      int           `firestore:"NoShows"`
+	AverageVisit time.Duration `firestore:"AverageVisit"`
+	PeakLength   int           `firestore:"PeakLength"`
+}
+
+// QueueLog 队列的事件记录，队列解散后仍然保留
+type QueueLog struct {
+	ID          string       `firestore:"-"`
+	Name        string       `firestore:"Name"`
+	OwnerID     int64        `firestore:"OwnerID"`
+	Owner       string       `firestore:"Owner"`
+	CreatedAt   time.Time    `firestore:"CreatedAt"`
+	DismissedAt time.Time    `firestore:"DismissedAt,omitempty"`
+	Summary     QueueSummary `firestore:"Summary"`
+}
+
+// RecordQueueEvent append event into queue's log
+func RecordQueueEvent(ctx context.Context, queue *OnboardQueue, event QueueEvent) (err error) {
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return
+	}
+	defer client.Close()
+	logRef := client.Doc("queueLogs/" + queue.ID)
+	batch := client.Batch()
+	batch.Set(logRef, map[string]interface{}{
+		"Name":    queue.Name,
+		"OwnerID": queue.OwnerID,
+		"Owner":   queue.Owner,
+	}, firestore.MergeAll)
+	batch.Create(logRef.Collection("events").NewDoc(), event)
+	_, err = batch.Commit(ctx)
+	return
+}
+
+// GetQueueLog return log of queue
+func GetQueueLog(ctx context.Context, queueID string) (queueLog *QueueLog, err error) {
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return
+	}
+	defer client.Close()
+	dsnap, err := client.Doc("queueLogs/" + queueID).Get(ctx)
+	if err != nil {
+		return
+	}
+	queueLog = &QueueLog{}
+	if err = dsnap.DataTo(queueLog); err != nil {
+		return nil, err
+	}
+	queueLog.ID = dsnap.Ref.ID
+	return
+}
+
+// GetQueueLogsByOwner return logs of owner's queues
+func GetQueueLogsByOwner(ctx context.Context, ownerID int64) (logs []QueueLog, err error) {
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return
+	}
+	defer client.Close()
+	iter := client.Collection("queueLogs").Where("OwnerID", "==", ownerID).Documents(ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var l QueueLog
+		if err = doc.DataTo(&l); err != nil {
+			logger.Warn().Err(err).Msg("GetQueueLogsByOwner")
+			continue
+		}
+		l.ID = doc.Ref.ID
+		logs = append(logs, l)
+	}
+	return logs, nil
+}
+
+// GetQueueEvents return events of queue ordered by time
+func GetQueueEvents(ctx context.Context, queueID string) (events []QueueEvent, err error) {
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return
+	}
+	defer client.Close()
+	iter := client.Collection("queueLogs/"+queueID+"/events").OrderBy("Time", firestore.Asc).Documents(ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var e QueueEvent
+		if err = doc.DataTo(&e); err != nil {
+			logger.Warn().Err(err).Msg("GetQueueEvents")
+			continue
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// SummarizeQueueEvents 根据事件计算接待人数、未到人数、平均登岛时长和最长排队
+func SummarizeQueueEvents(events []QueueEvent) (summary QueueSummary) {
+	var invited = make(map[int64]time.Time)
+	var total time.Duration
+	var visits int
+	for _, e := range events {
+		if e.QueueLength > summary.PeakLength {
+			summary.PeakLength = e.QueueLength
+		}
+		switch e.Type {
+		case QueueEventInvite:
+			invited[e.UID] = e.Time
+		case QueueEventDone:
+			summary.Served++
+			if t, ok := invited[e.UID]; ok {
+				total += e.Time.Sub(t)
+				visits++
+				delete(invited, e.UID)
+			}
+		case QueueEventTimeout:
+			summary.NoShows++
+		}
+	}
+	if visits > 0 {
+		summary.AverageVisit = total / time.Duration(visits)
+	}
+	return
+}
+
+// FinishQueueLog save summary and dismiss time into queue's log
+func FinishQueueLog(ctx context.Context, queue *OnboardQueue, summary QueueSummary) (err error) {
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return
+	}
+	defer client.Close()
+	_, err = client.Doc("queueLogs/"+queue.ID).Set(ctx, map[string]interface{}{
+		"Name":        queue.Name,
+		"OwnerID":     queue.OwnerID,
+		"Owner":       queue.Owner,
+		"DismissedAt": time.Now(),
+		"Summary":     summary,
+	}, firestore.MergeAll)
+	return
+}
@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	mathrand "math/rand"
+	"sort"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// IsLotteryOpen 抽签报名是否还在进行中
+func (q *OnboardQueue) IsLotteryOpen(now time.Time) bool {
+	return q != nil && !q.LotteryEndsAt.IsZero() && now.Before(q.LotteryEndsAt)
+}
+
+// IsLotteryPending 抽签队列还没有抽签，报名中或者报名已截止等待抽签
+func (q *OnboardQueue) IsLotteryPending() bool {
+	return q != nil && !q.LotteryEndsAt.IsZero()
+}
+
+// LotterySeedHash 抽签种子的 sha256，报名期间公布，抽签后公布种子供客人验证
+func (q *OnboardQueue) LotterySeedHash() string {
+	sum := sha256.Sum256([]byte(q.LotterySeed))
+	return hex.EncodeToString(sum[:])
+}
+
+// LotteryToken 抽签时代替 UID 公布的客人编号
+func (q *OnboardQueue) LotteryToken(uid int64) string {
+	sum := sha256.Sum256([]byte(q.ID + "|" + strconv.FormatInt(uid, 10)))
+	return hex.EncodeToString(sum[:])[:8]
+}
+
+// StartLottery 开始抽签报名，slots 为抽中的人数，0 为所有人都抽签排序
+func (q *OnboardQueue) StartLottery(ctx context.Context, client *firestore.Client, endsAt time.Time, slots int) (err error) {
+	if q == nil || len(q.ID) == 0 {
+		return errors.New("queue not exists")
+	}
+	if !q.LotteryEndsAt.IsZero() {
+		return errors.New("lottery already started")
+	}
+	b := make([]byte, 8)
+	if _, err = rand.Read(b); err != nil {
+		return
+	}
+	seed := hex.EncodeToString(b)
+	_, err = client.Doc("onboardQueues/"+q.ID).Update(ctx, []firestore.Update{
+		{Path: "LotteryEndsAt", Value: endsAt},
+		{Path: "LotterySlots", Value: slots},
+		{Path: "LotterySeed", Value: seed},
+	})
+	if err != nil {
+		return
+	}
+	q.LotteryEndsAt, q.LotterySlots, q.LotterySeed = endsAt, slots, seed
+	return
+}
+
+// ShuffleLotteryTokens 编号按字典序排序后，以种子打乱顺序，任何人拿到种子和编号都可以重算
+func ShuffleLotteryTokens(tokens []string, seed string) (shuffled []string, err error) {
+	s, err := strconv.ParseUint(seed, 16, 64)
+	if err != nil {
+		return
+	}
+	shuffled = append([]string{}, tokens...)
+	sort.Strings(shuffled)
+	r := mathrand.New(mathrand.NewSource(int64(s)))
+	r.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+	return
+}
+
+// DrawLottery 报名截止后抽签，抽中的客人按抽签顺序组成队列，没抽中的客人移出队列
+// 在事务中再次确认报名已截止且还没有抽过签，每次抽签只会执行一次
+func (q *OnboardQueue) DrawLottery(ctx context.Context, client *firestore.Client, now time.Time) (winners, losers []guest, err error) {
+	if q == nil || len(q.ID) == 0 {
+		return nil, nil, errors.New("queue not exists")
+	}
+	var fresh OnboardQueue
+	ref := client.Doc("onboardQueues/" + q.ID)
+	err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		winners, losers = nil, nil
+		dsnap, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		fresh = OnboardQueue{}
+		if err = dsnap.DataTo(&fresh); err != nil {
+			return err
+		}
+		if fresh.LotteryEndsAt.IsZero() {
+			return errors.New("lottery not started")
+		}
+		if fresh.IsLotteryOpen(now) {
+			return errors.New("lottery is open")
+		}
+		fresh.ID = q.ID
+		var guests = make(map[string]guest)
+		var tokens []string
+		for _, g := range fresh.Queue {
+			token := fresh.LotteryToken(g.UID)
+			guests[token] = g
+			tokens = append(tokens, token)
+		}
+		order, err := ShuffleLotteryTokens(tokens, fresh.LotterySeed)
+		if err != nil {
+			return err
+		}
+		for i, token := range order {
+			if fresh.LotterySlots > 0 && i >= fresh.LotterySlots {
+				losers = append(losers, guests[token])
+			} else {
+				winners = append(winners, guests[token])
+			}
+		}
+		fresh.Queue = append([]guest{}, winners...)
+		fresh.UIDs = []int64{}
+		for _, g := range winners {
+			fresh.UIDs = append(fresh.UIDs, g.UID)
+		}
+		return tx.Update(ref, []firestore.Update{
+			{Path: "queue", Value: fresh.Queue},
+			{Path: "uids", Value: fresh.UIDs},
+			{Path: "LotteryEndsAt", Value: firestore.Delete},
+		})
+	})
+	if err != nil {
+		return
+	}
+	q.Queue, q.UIDs, q.LotteryEndsAt = fresh.Queue, fresh.UIDs, time.Time{}
+	return
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+)
+
+// encryptedPasswordPrefix 加密后的队列密码前缀，没有此前缀的是旧的明文密码
+const encryptedPasswordPrefix = "enc:v1:"
+
+var passwordAEAD cipher.AEAD
+
+// InitPasswordKey init the key used to encrypt queue passwords, key is base64 encoded 32 bytes
+func InitPasswordKey(key string) (err error) {
+	k, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return
+	}
+	if len(k) != 32 {
+		return errors.New("password key must be 32 bytes")
+	}
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return
+	}
+	passwordAEAD, err = cipher.NewGCM(block)
+	return
+}
+
+// encryptPassword 加密队列密码
+func encryptPassword(password string) (encrypted string, err error) {
+	if passwordAEAD == nil {
+		return "", errors.New("password key not init")
+	}
+	nonce := make([]byte, passwordAEAD.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return
+	}
+	sealed := passwordAEAD.Seal(nonce, nonce, []byte(password), nil)
+	return encryptedPasswordPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// SetPassword encrypt password and set it to queue, call Update to save it
+func (q *OnboardQueue) SetPassword(password string) (err error) {
+	encrypted, err := encryptPassword(password)
+	if err != nil {
+		return
+	}
+	q.Password = encrypted
+	return
+}
+
+// PlainPassword decrypt password of queue, only use it when send password to guest
+func (q *OnboardQueue) PlainPassword() (password string, err error) {
+	if !strings.HasPrefix(q.Password, encryptedPasswordPrefix) {
+		return q.Password, nil
+	}
+	if passwordAEAD == nil {
+		return "", errors.New("password key not init")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(q.Password[len(encryptedPasswordPrefix):])
+	if err != nil {
+		return
+	}
+	nonceSize := passwordAEAD.NonceSize()
+	if len(sealed) < nonceSize {
+		return "", errors.New("invalid encrypted password")
+	}
+	plain, err := passwordAEAD.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return
+	}
+	return string(plain), nil
+}
+
+// EncryptLegacyPassword encrypt plain text password of old queue
+func (q *OnboardQueue) EncryptLegacyPassword(ctx context.Context, client *firestore.Client) (err error) {
+	if q == nil || len(q.ID) == 0 {
+		return errors.New("queue not exists")
+	}
+	if len(q.Password) == 0 || strings.HasPrefix(q.Password, encryptedPasswordPrefix) {
+		return
+	}
+	encrypted, err := encryptPassword(q.Password)
+	if err != nil {
+		return
+	}
+	if err = q.UpdateSetting(ctx, client, "Password", encrypted); err != nil {
+		return
+	}
+	q.Password = encrypted
+	return
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"context"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+// removeUID return uids without uid
+func removeUID(uids []int64, uid int64) []int64 {
+	var result = []int64{}
+	for _, u := range uids {
+		if u != uid {
+			result = append(result, u)
+		}
+	}
+	return result
+}
+
+// GetLandedQueue return the queue which uid has been invited to, nil if not found
+func GetLandedQueue(ctx context.Context, client *firestore.Client, uid int64) (queue *OnboardQueue, err error) {
+	iter := client.Collection("onboardQueues").Where("LandedUIDs", "array-contains", uid).Documents(ctx)
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		q := &OnboardQueue{}
+		if err = doc.DataTo(q); err != nil {
+			logger.Warn().Err(err).Msg("GetLandedQueue")
+			continue
+		}
+		if q.Dismissed || !q.IsLanded(uid) {
+			continue
+		}
+		q.ID = doc.Ref.ID
+		return q, nil
+	}
+	return nil, nil
+}
+
+// SyncLandedUIDs 旧队列没有 LandedUIDs，根据在岛客人补上
+func (q *OnboardQueue) SyncLandedUIDs(ctx context.Context, client *firestore.Client) (err error) {
+	if q == nil || len(q.ID) == 0 || len(q.LandedUIDs) == len(q.Landed) {
+		return
+	}
+	var uids = []int64{}
+	for _, g := range q.Landed {
+		uids = append(uids, g.UID)
+	}
+	if _, err = client.Doc("onboardQueues/"+q.ID).Update(ctx, []firestore.Update{
+		{Path: "LandedUIDs", Value: uids},
+	}); err != nil {
+		return
+	}
+	q.LandedUIDs = uids
+	return
+}
@@ -0,0 +1,223 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+// QueueSchedule 预约开放的登岛队列，到时间后由岛主输入密码开启
+type QueueSchedule struct {
+	ID            string    `firestore:"-"`
+	Name          string    `firestore:"Name"`
+	OwnerID       int64     `firestore:"OwnerID"`
+	Owner         string    `firestore:"Owner"`
+	StartTime     time.Time `firestore:"StartTime"`
+	Timezone      Timezone  `firestore:"Timezone"` // 岛主的时区，用于显示开放时间
+	MaxGuestCount int       `firestore:"MaxGuestCount"`
+	Guests        []guest   `firestore:"guests"`   // 预先登记的客人，按登记顺序
+	Notified      bool      `firestore:"Notified"` // 已提醒岛主输入密码
+}
+
+// Len 预先登记的客人数
+func (s *QueueSchedule) Len() int {
+	return len(s.Guests)
+}
+
+// LocalStartTime 岛主时区的开放时间
+func (s *QueueSchedule) LocalStartTime() time.Time {
+	return s.StartTime.In(s.Timezone.Location())
+}
+
+// GuestUIDs 预先登记的客人的 uid
+func (s *QueueSchedule) GuestUIDs() (uids []int64) {
+	for _, g := range s.Guests {
+		uids = append(uids, g.UID)
+	}
+	return
+}
+
+// HasGuest return whether uid has pre-registered
+func (s *QueueSchedule) HasGuest(uid int64) bool {
+	for _, g := range s.Guests {
+		if g.UID == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateQueueSchedule save a new queue schedule
+func CreateQueueSchedule(ctx context.Context, schedule *QueueSchedule) (err error) {
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return
+	}
+	defer client.Close()
+	ref := client.Collection("queueSchedules").NewDoc()
+	if _, err = ref.Create(ctx, schedule); err != nil {
+		return
+	}
+	schedule.ID = ref.ID
+	return
+}
+
+// GetQueueSchedule return a exists queue schedule
+func GetQueueSchedule(ctx context.Context, client *firestore.Client, scheduleID string) (schedule *QueueSchedule, err error) {
+	dsnap, err := client.Doc("queueSchedules/" + scheduleID).Get(ctx)
+	if err != nil {
+		return
+	}
+	schedule = &QueueSchedule{}
+	if err = dsnap.DataTo(schedule); err != nil {
+		return nil, err
+	}
+	schedule.ID = dsnap.Ref.ID
+	return
+}
+
+// GetQueueScheduleByOwner return the pending schedule of owner, nil if not exists
+func GetQueueScheduleByOwner(ctx context.Context, client *firestore.Client, ownerID int64) (schedule *QueueSchedule, err error) {
+	iter := client.Collection("queueSchedules").Where("OwnerID", "==", ownerID).Limit(1).Documents(ctx)
+	defer iter.Stop()
+	doc, err := iter.Next()
+	if err == iterator.Done {
+		return nil, nil
+	}
+	if err != nil {
+		return
+	}
+	schedule = &QueueSchedule{}
+	if err = doc.DataTo(schedule); err != nil {
+		return nil, err
+	}
+	schedule.ID = doc.Ref.ID
+	return
+}
+
+// GetDueQueueSchedules return schedules whose start time is before now
+func GetDueQueueSchedules(ctx context.Context, client *firestore.Client, now time.Time) (schedules []*QueueSchedule, err error) {
+	iter := client.Collection("queueSchedules").Where("StartTime", "<=", now).Documents(ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		s := &QueueSchedule{}
+		if err = doc.DataTo(s); err != nil {
+			logger.Warn().Err(err).Msg("GetDueQueueSchedules")
+			continue
+		}
+		s.ID = doc.Ref.ID
+		schedules = append(schedules, s)
+	}
+	return schedules, nil
+}
+
+// AddGuest pre-register guest into schedule
+func (s *QueueSchedule) AddGuest(ctx context.Context, client *firestore.Client, uid int64, username string) (err error) {
+	if s.HasGuest(uid) {
+		return errors.New("already in this queue")
+	}
+	var g = guest{UID: uid, Name: username}
+	_, err = client.Doc("queueSchedules/"+s.ID).Update(ctx, []firestore.Update{
+		{Path: "guests", Value: firestore.ArrayUnion(g)},
+	})
+	if err != nil {
+		return
+	}
+	s.Guests = append(s.Guests, g)
+	return
+}
+
+// RemoveGuest remove pre-registered guest from schedule
+func (s *QueueSchedule) RemoveGuest(ctx context.Context, client *firestore.Client, uid int64) (err error) {
+	for i, g := range s.Guests {
+		if g.UID != uid {
+			continue
+		}
+		_, err = client.Doc("queueSchedules/"+s.ID).Update(ctx, []firestore.Update{
+			{Path: "guests", Value: firestore.ArrayRemove(g)},
+		})
+		if err != nil {
+			return
+		}
+		s.Guests = append(s.Guests[:i], s.Guests[i+1:]...)
+		return
+	}
+	return errors.New("not join in this queue")
+}
+
+// MarkNotified record owner has been asked for password,
+// return error when already marked, so owner is asked only once
+func (s *QueueSchedule) MarkNotified(ctx context.Context, client *firestore.Client) (err error) {
+	ref := client.Doc("queueSchedules/" + s.ID)
+	err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		dsnap, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		var fresh QueueSchedule
+		if err = dsnap.DataTo(&fresh); err != nil {
+			return err
+		}
+		if fresh.Notified {
+			return errors.New("already notified")
+		}
+		return tx.Update(ref, []firestore.Update{
+			{Path: "Notified", Value: true},
+		})
+	})
+	if err != nil {
+		return
+	}
+	s.Notified = true
+	return
+}
+
+// Delete schedule, return NotFound when it has already been deleted
+func (s *QueueSchedule) Delete(ctx context.Context, client *firestore.Client) (err error) {
+	ref := client.Doc("queueSchedules/" + s.ID)
+	return client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		if _, err := tx.Get(ref); err != nil {
+			return err
+		}
+		return tx.Delete(ref)
+	})
+}
+
+// Open create onboard queue for island, pre-registered guests are appended in order, then remove the schedule
+// all in one transaction, so the schedule can only be opened once
+func (s *QueueSchedule) Open(ctx context.Context, client *firestore.Client, island *Island, password string) (queue *OnboardQueue, err error) {
+	queue, err = island.newOnboardQueue(s.OwnerID, s.Owner, password, s.MaxGuestCount)
+	if err != nil {
+		return nil, err
+	}
+	ref := client.Doc("queueSchedules/" + s.ID)
+	err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		dsnap, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		var fresh QueueSchedule
+		if err = dsnap.DataTo(&fresh); err != nil {
+			return err
+		}
+		queue.Queue = append([]guest{}, fresh.Guests...)
+		queue.UIDs = fresh.GuestUIDs()
+		if err = island.createOnboardQueue(client, tx, queue); err != nil {
+			return err
+		}
+		return tx.Delete(ref)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return
+}
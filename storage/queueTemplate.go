@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+// QueueTemplate 岛主保存的队列模板，用于一步开启相同设置的队列
+type QueueTemplate struct {
+	Name               string `firestore:"Name"`
+	Info               string `firestore:"Info"`               // 本次开岛特色信息
+	Intro              string `firestore:"Intro"`              // 给客人的说明
+	MaxGuestCount      int    `firestore:"MaxGuestCount"`      // 0 为手动队列
+	InviteTimeout      int    `firestore:"InviteTimeout"`      // 见 OnboardQueue.InviteTimeout
+	OnlyReliableGuests bool   `firestore:"OnlyReliableGuests"` // 见 OnboardQueue.OnlyReliableGuests
+	MemberPrivacy      string `firestore:"MemberPrivacy"`      // 见 OnboardQueue.MemberPrivacy
+	MaxQueueLength     int    `firestore:"MaxQueueLength"`     // 见 OnboardQueue.MaxQueueLength
+}
+
+func queueTemplatesPath(uid int) string {
+	return fmt.Sprintf("users/%d/queueTemplates", uid)
+}
+
+// SaveQueueTemplate save template of user, template with same name will be replaced
+func SaveQueueTemplate(ctx context.Context, uid int, template QueueTemplate) (err error) {
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return
+	}
+	defer client.Close()
+	_, err = client.Collection(queueTemplatesPath(uid)).Doc(template.Name).Set(ctx, template)
+	return
+}
+
+// GetQueueTemplate return template of user by name
+func GetQueueTemplate(ctx context.Context, uid int, name string) (template *QueueTemplate, err error) {
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return
+	}
+	defer client.Close()
+	dsnap, err := client.Collection(queueTemplatesPath(uid)).Doc(name).Get(ctx)
+	if err != nil {
+		return
+	}
+	template = &QueueTemplate{}
+	if err = dsnap.DataTo(template); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// GetQueueTemplates return all templates of user
+func GetQueueTemplates(ctx context.Context, uid int) (templates []QueueTemplate, err error) {
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return
+	}
+	defer client.Close()
+	iter := client.Collection(queueTemplatesPath(uid)).Documents(ctx)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var t QueueTemplate
+		if err = doc.DataTo(&t); err != nil {
+			logger.Warn().Err(err).Msg("GetQueueTemplates")
+			continue
+		}
+		templates = append(templates, t)
+	}
+	return templates, nil
+}
+
+// DeleteQueueTemplate delete template of user by name
+func DeleteQueueTemplate(ctx context.Context, uid int, name string) (err error) {
+	client, err := firestore.NewClient(ctx, projectID)
+	if err != nil {
+		return
+	}
+	defer client.Close()
+	_, err = client.Collection(queueTemplatesPath(uid)).Doc(name).Delete(ctx)
+	return
+}
+
+// ApplyTemplate 把模板中的队列设置写入队列
+func (q *OnboardQueue) ApplyTemplate(ctx context.Context, client *firestore.Client, template QueueTemplate) (err error) {
+	if q == nil || len(q.ID) == 0 {
+		return
+	}
+	_, err = client.Doc("onboardQueues/"+q.ID).Update(ctx, []firestore.Update{
+		{Path: "Intro", Value: template.Intro},
+		{Path: "InviteTimeout", Value: template.InviteTimeout},
+		{Path: "OnlyReliableGuests", Value: template.OnlyReliableGuests},
+		{Path: "MemberPrivacy", Value: template.MemberPrivacy},
+		{Path: "MaxQueueLength", Value: template.MaxQueueLength},
+	})
+	if err != nil {
+		return
+	}
+	q.Intro = template.Intro
+	q.InviteTimeout = template.InviteTimeout
+	q.OnlyReliableGuests = template.OnlyReliableGuests
+	q.MemberPrivacy = template.MemberPrivacy
+	q.MaxQueueLength = template.MaxQueueLength
+	return
+}
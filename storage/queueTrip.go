@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"cloud.google.com/go/firestore"
+)
+
+// 客人登岛的目的
+const (
+	GuestPurposeTurnip  = "turnip"  // 卖大头菜
+	GuestPurposeKicks   = "kicks"   // 买曹卖的商品
+	GuestPurposeVisitor = "visitor" // 找岛上的特殊访客
+	GuestPurposeOther   = "other"   // 其他
+)
+
+// MaxGuestTrips 客人一次排队最多可以登岛的次数
+const MaxGuestTrips = 5
+
+// GuestPurpose 客人登岛的目的，没有选择时为空
+func (q *OnboardQueue) GuestPurpose(uid int64) string {
+	if q == nil {
+		return ""
+	}
+	return q.GuestPurposes[strconv.FormatInt(uid, 10)]
+}
+
+// GuestTrips 客人剩余的登岛次数，包括当前这一次，没有选择时为 1
+func (q *OnboardQueue) GuestTrips(uid int64) int {
+	if q == nil {
+		return 1
+	}
+	if trips, ok := q.RemainingTrips[strconv.FormatInt(uid, 10)]; ok && trips > 0 {
+		return trips
+	}
+	return 1
+}
+
+// SetGuestVisit 记录客人登岛的目的和次数
+func (q *OnboardQueue) SetGuestVisit(ctx context.Context, client *firestore.Client, uid int64, purpose string, trips int) (err error) {
+	if q == nil || len(q.ID) == 0 {
+		return errors.New("queue not exists")
+	}
+	if trips < 1 || trips > MaxGuestTrips {
+		return errors.New("invalid trips")
+	}
+	key := strconv.FormatInt(uid, 10)
+	_, err = client.Doc("onboardQueues/"+q.ID).Update(ctx, []firestore.Update{
+		{FieldPath: firestore.FieldPath{"GuestPurposes", key}, Value: purpose},
+		{FieldPath: firestore.FieldPath{"RemainingTrips", key}, Value: trips},
+	})
+	if err != nil {
+		return
+	}
+	if q.GuestPurposes == nil {
+		q.GuestPurposes = make(map[string]string)
+	}
+	if q.RemainingTrips == nil {
+		q.RemainingTrips = make(map[string]int)
+	}
+	q.GuestPurposes[key] = purpose
+	q.RemainingTrips[key] = trips
+	return
+}
+
+// NextTrip 多次登岛的客人完成一次登岛后，回到队尾排下一次，剩余次数减一
+// 队列满员或已有人候补时，和新加入的客人一样进入候补名单
+func (q *OnboardQueue) NextTrip(ctx context.Context, client *firestore.Client, uid int64) (waitlisted bool, err error) {
+	if q == nil || len(q.ID) == 0 {
+		return false, errors.New("queue not exists")
+	}
+	var fresh OnboardQueue
+	key := strconv.FormatInt(uid, 10)
+	ref := client.Doc("onboardQueues/" + q.ID)
+	err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		dsnap, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		fresh = OnboardQueue{}
+		if err = dsnap.DataTo(&fresh); err != nil {
+			return err
+		}
+		if fresh.Dismissed {
+			return errors.New("queue has been dismissed")
+		}
+		if fresh.GuestTrips(uid) <= 1 {
+			return errors.New("no trips left")
+		}
+		landed := []guest{}
+		var requeued *guest
+		for i, g := range fresh.Landed {
+			if g.UID == uid {
+				requeued = &fresh.Landed[i]
+				continue
+			}
+			landed = append(landed, g)
+		}
+		if requeued == nil {
+			return errors.New("not land island")
+		}
+		waitlisted = fresh.IsFull() || len(fresh.Waitlist) > 0
+		if waitlisted {
+			fresh.Waitlist = append(fresh.Waitlist, *requeued)
+		} else {
+			fresh.Queue = append(fresh.Queue, *requeued)
+			fresh.UIDs = append(fresh.UIDs, uid)
+		}
+		fresh.Landed = landed
+		fresh.RemainingTrips[key]--
+		return tx.Update(ref, []firestore.Update{
+			{Path: "queue", Value: fresh.Queue},
+			{Path: "uids", Value: fresh.UIDs},
+			{Path: "waitlist", Value: fresh.Waitlist},
+			{Path: "landed", Value: fresh.Landed},
+			{Path: "LandedUIDs", Value: firestore.ArrayRemove(uid)},
+			{FieldPath: firestore.FieldPath{"RemainingTrips", key}, Value: fresh.RemainingTrips[key]},
+			{FieldPath: firestore.FieldPath{"RejoinDeadlines", key}, Value: firestore.Delete},
+			{FieldPath: firestore.FieldPath{"InviteDeadlines", key}, Value: firestore.Delete},
+			{FieldPath: firestore.FieldPath{"LandedTimes", key}, Value: firestore.Delete},
+		})
+	})
+	if err != nil {
+		return
+	}
+	q.Queue, q.UIDs, q.Landed, q.Waitlist = fresh.Queue, fresh.UIDs, fresh.Landed, fresh.Waitlist
+	q.LandedUIDs = removeUID(q.LandedUIDs, uid)
+	if q.RemainingTrips == nil {
+		q.RemainingTrips = make(map[string]int)
+	}
+	q.RemainingTrips[key] = fresh.RemainingTrips[key]
+	delete(q.RejoinDeadlines, key)
+	delete(q.InviteDeadlines, key)
+	delete(q.LandedTimes, key)
+	return
+}
@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"cloud.google.com/go/firestore"
+)
+
+// IsFull return whether queue reached MaxQueueLength
+func (q *OnboardQueue) IsFull() bool {
+	return q.MaxQueueLength > 0 && q.Len() >= q.MaxQueueLength
+}
+
+// WaitlistLen return length of Waitlist
+func (q *OnboardQueue) WaitlistLen() int {
+	if q == nil {
+		return 0
+	}
+	return len(q.Waitlist)
+}
+
+// GetWaitlistPosition return position of uid in waitlist, start from 1
+func (q *OnboardQueue) GetWaitlistPosition(uid int64) (int, error) {
+	for i, g := range q.Waitlist {
+		if g.UID == uid {
+			return i + 1, nil
+		}
+	}
+	return -1, errors.New("NotFound")
+}
+
+// Join append uid into queue, or into waitlist when queue reached MaxQueueLength
+func (q *OnboardQueue) Join(ctx context.Context, client *firestore.Client, uid int64, username string) (waitlisted bool, err error) {
+	if q == nil || len(q.ID) == 0 {
+		return
+	}
+	if q.MaxQueueLength == 0 && len(q.Waitlist) == 0 {
+		return false, q.Append(ctx, client, uid, username)
+	}
+	var fresh OnboardQueue
+	var p = guest{UID: uid, Name: username}
+	ref := client.Doc("onboardQueues/" + q.ID)
+	err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		dsnap, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		fresh = OnboardQueue{}
+		if err = dsnap.DataTo(&fresh); err != nil {
+			return err
+		}
+		if fresh.Dismissed {
+			return errors.New("queue has been dismissed")
+		}
+		for _, g := range fresh.Queue {
+			if g.UID == uid {
+				return errors.New("already in this queue")
+			}
+		}
+		for _, g := range fresh.Landed {
+			if g.UID == uid {
+				return errors.New("already land island")
+			}
+		}
+		for _, g := range fresh.Waitlist {
+			if g.UID == uid {
+				return errors.New("already in waitlist")
+			}
+		}
+		// 已有人候补时，新来的客人也要排在候补名单后面
+		waitlisted = fresh.IsFull() || len(fresh.Waitlist) > 0
+		if waitlisted {
+			return tx.Update(ref, []firestore.Update{
+				{Path: "waitlist", Value: firestore.ArrayUnion(p)},
+			})
+		}
+		return tx.Update(ref, []firestore.Update{
+			{Path: "queue", Value: firestore.ArrayUnion(p)},
+			{Path: "uids", Value: firestore.ArrayUnion(uid)},
+		})
+	})
+	if err != nil {
+		return
+	}
+	q.Queue, q.UIDs, q.Landed, q.Waitlist = fresh.Queue, fresh.UIDs, fresh.Landed, fresh.Waitlist
+	if waitlisted {
+		q.Waitlist = append(q.Waitlist, p)
+	} else {
+		q.Queue = append(q.Queue, p)
+		q.UIDs = append(q.UIDs, uid)
+	}
+	return
+}
+
+// LeaveWaitlist remove uid from waitlist
+func (q *OnboardQueue) LeaveWaitlist(ctx context.Context, client *firestore.Client, uid int64) (err error) {
+	for i, g := range q.Waitlist {
+		if g.UID != uid {
+			continue
+		}
+		_, err = client.Doc("onboardQueues/"+q.ID).Update(ctx, []firestore.Update{
+			{Path: "waitlist", Value: firestore.ArrayRemove(g)},
+			{FieldPath: firestore.FieldPath{"GuestPurposes", strconv.FormatInt(uid, 10)}, Value: firestore.Delete},
+			{FieldPath: firestore.FieldPath{"RemainingTrips", strconv.FormatInt(uid, 10)}, Value: firestore.Delete},
+		})
+		if err != nil {
+			return
+		}
+		q.Waitlist = append(q.Waitlist[:i], q.Waitlist[i+1:]...)
+		delete(q.GuestPurposes, strconv.FormatInt(uid, 10))
+		delete(q.RemainingTrips, strconv.FormatInt(uid, 10))
+		return
+	}
+	return errors.New("not in waitlist")
+}
+
+// PromoteWaitlist move guests in waitlist into queue while there are free slots
+func (q *OnboardQueue) PromoteWaitlist(ctx context.Context, client *firestore.Client) (promoted []guest, err error) {
+	if q == nil || len(q.ID) == 0 || len(q.Waitlist) == 0 {
+		return
+	}
+	var fresh OnboardQueue
+	ref := client.Doc("onboardQueues/" + q.ID)
+	err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		promoted = nil
+		dsnap, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		fresh = OnboardQueue{}
+		if err = dsnap.DataTo(&fresh); err != nil {
+			return err
+		}
+		if fresh.Dismissed {
+			return nil
+		}
+		free := len(fresh.Waitlist)
+		if fresh.MaxQueueLength > 0 && fresh.MaxQueueLength-len(fresh.Queue) < free {
+			free = fresh.MaxQueueLength - len(fresh.Queue)
+		}
+		if free <= 0 {
+			return nil
+		}
+		promoted = append([]guest{}, fresh.Waitlist[:free]...)
+		for _, g := range promoted {
+			fresh.Queue = append(fresh.Queue, g)
+			fresh.UIDs = append(fresh.UIDs, g.UID)
+		}
+		fresh.Waitlist = fresh.Waitlist[free:]
+		return tx.Update(ref, []firestore.Update{
+			{Path: "queue", Value: fresh.Queue},
+			{Path: "uids", Value: fresh.UIDs},
+			{Path: "waitlist", Value: fresh.Waitlist},
+		})
+	})
+	if err != nil || len(promoted) == 0 {
+		return
+	}
+	q.Queue, q.UIDs, q.Landed, q.Waitlist = fresh.Queue, fresh.UIDs, fresh.Landed, fresh.Waitlist
+	return
+}
@@ -0,0 +1,18 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AppEngineCron 只允许 App Engine cron 调用，GAE 会去掉外部请求带的 X-Appengine-Cron 头
+func AppEngineCron() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader("X-Appengine-Cron") != "true" {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,101 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/doylecnn/new-nsfc-bot/storage"
+	"github.com/doylecnn/new-nsfc-bot/web/middleware"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/gin-gonic/gin"
+)
+
+// authedUserID 从登录信息中获得当前用户 id
+func authedUserID(c *gin.Context) (uid int64, ok bool) {
+	v, exists := c.Get("authed")
+	if !exists {
+		return
+	}
+	if authed, isBool := v.(bool); !isBool || !authed {
+		return
+	}
+	authData, _ := c.Cookie("auth_data_str")
+	userID, err := middleware.GetAuthDataInfo(authData, "id")
+	if err != nil {
+		_logger.Warn().Err(err).Msg("get auth data info")
+		return
+	}
+	uid, err = strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		_logger.Warn().Err(err).Msg("parse int")
+		return
+	}
+	return uid, true
+}
+
+// QueueLogs list logs of queues created by current user
+func (w Web) QueueLogs(c *gin.Context) {
+	uid, ok := authedUserID(c)
+	if !ok {
+		c.Redirect(http.StatusTemporaryRedirect, "/login")
+		return
+	}
+	logs, err := storage.GetQueueLogsByOwner(context.Background(), uid)
+	if err != nil {
+		_logger.Warn().Err(err).Msg("get queue logs")
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	sort.Slice(logs, func(i, j int) bool {
+		return logs[i].CreatedAt.After(logs[j].CreatedAt)
+	})
+	c.HTML(200, "queuelogs.html", gin.H{
+		"uid":  uid,
+		"logs": logs,
+	})
+}
+
+// QueueLog show events of a queue, only the owner can view it
+func (w Web) QueueLog(c *gin.Context) {
+	uid, ok := authedUserID(c)
+	if !ok {
+		c.Redirect(http.StatusTemporaryRedirect, "/login")
+		return
+	}
+	ctx := context.Background()
+	queueLog, err := storage.GetQueueLog(ctx, c.Param("queueid"))
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		_logger.Warn().Err(err).Msg("get queue log")
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if queueLog.OwnerID != uid {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+	events, err := storage.GetQueueEvents(ctx, queueLog.ID)
+	if err != nil {
+		_logger.Warn().Err(err).Msg("get queue events")
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	summary := queueLog.Summary
+	if queueLog.DismissedAt.IsZero() {
+		// 队列还没有解散，按目前的记录统计
+		summary = storage.SummarizeQueueEvents(events)
+	}
+	c.HTML(200, "queuelog.html", gin.H{
+		"uid":     uid,
+		"log":     queueLog,
+		"summary": summary,
+		"events":  events,
+	})
+}
@@ -83,6 +83,8 @@ func NewWeb(token, domain, appID, projectID, port string, adminID int, bot chatb
 	{
 		authorized.GET("/user/:userid", web.User)
 		authorized.GET("/islands", web.Islands)
+		authorized.GET("/queuelogs", web.QueueLogs)
+		authorized.GET("/queuelogs/:queueid", web.QueueLog)
 		authorized.GET("/logout", web.Logout)
 	}
 
@@ -95,6 +97,11 @@ func NewWeb(token, domain, appID, projectID, port string, adminID int, bot chatb
 		})
 	}
 
+	r.GET("/cron/checkqueues", middleware.AppEngineCron(), func(c *gin.Context) {
+		bot.CheckQueues()
+		c.JSON(http.StatusOK, "OK")
+	})
+
 	updates = make(chan tgbotapi.Update, bot.TgBotClient.Buffer)
 	r.POST("/"+token, func(c *gin.Context) {
 		bytes, _ := ioutil.ReadAll(c.Request.Body)